@@ -0,0 +1,467 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// gcloud refuses to upload sources larger than this
+	defaultMaxSourceSize = 100 * 1024 * 1024
+
+	// number of files listed in the source report
+	largestFilesInReport = 5
+)
+
+// used by gcloud if there's no .gcloudignore in the source directory
+var defaultGcloudIgnore = []string{
+	".gcloudignore",
+	".git",
+	".gitignore",
+	"node_modules",
+	"#!include:.gitignore",
+}
+
+// ignoreRule is a single pattern of a .gcloudignore file.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// IgnoreRules decide which files of a source directory are uploaded, using
+// the same syntax as .gcloudignore (and .gitignore) files.
+type IgnoreRules []ignoreRule
+
+// loadIgnoreRules returns the rules of the .gcloudignore in src or the
+// defaults that gcloud uses if there is none.
+func loadIgnoreRules(src string) (IgnoreRules, error) {
+	b, err := ioutil.ReadFile(filepath.Join(src, ".gcloudignore"))
+	if os.IsNotExist(err) {
+		return parseIgnoreRules(src, defaultGcloudIgnore)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseIgnoreRules(src, strings.Split(string(b), "\n"))
+}
+
+func parseIgnoreRules(src string, lines []string) (IgnoreRules, error) {
+	res := IgnoreRules{}
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \r")
+		if strings.HasPrefix(line, "#!include:") {
+			b, err := ioutil.ReadFile(filepath.Join(src, strings.TrimPrefix(line, "#!include:")))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			included, err := parseIgnoreRules(src, strings.Split(string(b), "\n"))
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, included...)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		r := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+
+		re, err := regexp.Compile(ignorePatternToRegex(line, anchored))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %s", line, err)
+		}
+		r.re = re
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func ignorePatternToRegex(p string, anchored bool) string {
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '*':
+			if i+1 < len(p) && p[i+1] == '*' {
+				if i+2 < len(p) && p[i+2] == '/' {
+					b.WriteString("(?:.*/)?")
+					i += 2
+				} else {
+					b.WriteString(".*")
+					i++
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			if end := strings.IndexByte(p[i:], ']'); end != -1 {
+				class := p[i+1 : i+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				b.WriteString("[" + class + "]")
+				i += end
+				continue
+			}
+			b.WriteString(`\[`)
+		case '\\':
+			if i+1 < len(p) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(p[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// Ignored returns true if the file or directory with the slash separated
+// path rel, relative to the source directory, is not uploaded.
+func (rules IgnoreRules) Ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.re.MatchString(rel) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// ArchiveFile is a file that's part of a source archive.
+type ArchiveFile struct {
+	Path string
+	Size int64
+}
+
+// SourceArchive describes the zip archive built from a source directory.
+type SourceArchive struct {
+	Dir              string
+	Files            []ArchiveFile
+	UncompressedSize int64
+	Size             int64
+
+	// only set for archives that are kept to be uploaded
+	Path   string
+	SHA256 string
+
+	// temp archives are removed after deploying
+	temp bool
+}
+
+// LargestFiles returns the n largest files of the archive.
+func (a *SourceArchive) LargestFiles(n int) []ArchiveFile {
+	files := make([]ArchiveFile, len(a.Files))
+	copy(files, a.Files)
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Size > files[j].Size })
+	if len(files) > n {
+		files = files[:n]
+	}
+	return files
+}
+
+// Report returns a human readable summary of the archive.
+func (a *SourceArchive) Report() string {
+	lines := []string{fmt.Sprintf("Source %s: %d files, %s uncompressed, %s zipped", a.Dir, len(a.Files), formatSize(a.UncompressedSize), formatSize(a.Size))}
+	for _, f := range a.LargestFiles(largestFilesInReport) {
+		lines = append(lines, fmt.Sprintf("  %10s  %s", formatSize(f.Size), f.Path))
+	}
+	return strings.Join(lines, "\n")
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// buildSourceArchive zips the files of src that aren't ignored into w. The
+// archive only depends on the paths and contents of the files so identical
+// sources always result in identical archives.
+func buildSourceArchive(src string, w io.Writer) (*SourceArchive, error) {
+	rules, err := loadIgnoreRules(src)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SourceArchive{Dir: src}
+	err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == src {
+			return nil
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rules.Ignored(rel, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsRegular() {
+			res.Files = append(res.Files, ArchiveFile{Path: rel, Size: fi.Size()})
+			res.UncompressedSize += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cw := &countingWriter{w: w}
+	zw := zip.NewWriter(cw)
+	for _, f := range res.Files {
+		if err := addFileToZip(zw, filepath.Join(src, filepath.FromSlash(f.Path)), f.Path); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	res.Size = cw.n
+	return res, nil
+}
+
+func addFileToZip(zw *zip.Writer, p string, name string) error {
+	fh, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+
+	// fixed timestamps and modes so the archive only depends on the content
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	hdr.SetMode(0644)
+	if fi.Mode()&0111 != 0 {
+		hdr.SetMode(0755)
+	}
+
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, fh)
+	return err
+}
+
+var (
+	secretFileNameRegex = regexp.MustCompile(`(?i)(^|/)(\.env(\.[^/]*)?|[^/]*\.pem|[^/]*\.key|[^/]*\.p12|id_rsa[^/]*|id_ed25519[^/]*|credentials\.json)$`)
+
+	// env files that are meant to be committed and public keys
+	secretFileNameExceptions = regexp.MustCompile(`(?i)((^|/)\.env\.(example|sample|template|dist|ya?ml)|\.pub)$`)
+)
+
+// secretLookingFiles returns the files of the archive that look like they
+// contain secrets, e.g. .env files, private keys or service account keys.
+func secretLookingFiles(a *SourceArchive) []string {
+	res := []string{}
+	for _, f := range a.Files {
+		if secretFileNameRegex.MatchString(f.Path) && !secretFileNameExceptions.MatchString(f.Path) {
+			res = append(res, f.Path)
+			continue
+		}
+		if strings.HasSuffix(f.Path, ".json") && f.Size < 64*1024 && isServiceAccountKey(filepath.Join(a.Dir, filepath.FromSlash(f.Path))) {
+			res = append(res, f.Path)
+		}
+	}
+	return res
+}
+
+func isServiceAccountKey(p string) bool {
+	fh, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer fh.Close()
+
+	hasKey, hasType := false, false
+	s := bufio.NewScanner(fh)
+	for s.Scan() {
+		l := s.Text()
+		hasKey = hasKey || strings.Contains(l, `"private_key"`)
+		hasType = hasType || strings.Contains(l, `"service_account"`)
+	}
+	return hasKey && hasType
+}
+
+var sizeRegex = regexp.MustCompile(`^(\d+)\s*([KMG]I?B?|B)?$`)
+
+// parseSize parses sizes like 100MB or 512KiB, units are powers of 1024.
+func parseSize(s string) (int64, error) {
+	m := sizeRegex.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 100MB", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	switch strings.TrimSuffix(strings.TrimSuffix(m[2], "B"), "I") {
+	case "K":
+		n *= 1024
+	case "M":
+		n *= 1024 * 1024
+	case "G":
+		n *= 1024 * 1024 * 1024
+	}
+	return n, nil
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1024*1024*1024:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1024*1024*1024))
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1fKB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// sourceArchive returns the archive of src, each source directory is only
+// zipped once. If the sources are staged the archive is kept in a temp file
+// so it can be uploaded later on.
+func (cfg *Config) sourceArchive(src string) (*SourceArchive, error) {
+	if a, ok := cfg.archives[src]; ok {
+		return a, nil
+	}
+
+	if isZipSource(src) {
+		a, err := readZipArchive(src)
+		if err != nil {
+			return nil, err
+		}
+		cfg.cacheArchive(a)
+		return a, nil
+	}
+
+	if cfg.StagingBucket == "" {
+		a, err := buildSourceArchive(src, ioutil.Discard)
+		if err != nil {
+			return nil, err
+		}
+		cfg.cacheArchive(a)
+		return a, nil
+	}
+
+	fh, err := ioutil.TempFile("", "drone-gcf-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	h := sha256.New()
+	a, err := buildSourceArchive(src, io.MultiWriter(fh, h))
+	if err != nil {
+		os.Remove(fh.Name())
+		return nil, err
+	}
+	a.Path = fh.Name()
+	a.temp = true
+	a.SHA256 = hex.EncodeToString(h.Sum(nil))
+	cfg.cacheArchive(a)
+	return a, nil
+}
+
+func (cfg *Config) cacheArchive(a *SourceArchive) {
+	if cfg.archives == nil {
+		cfg.archives = map[string]*SourceArchive{}
+	}
+	cfg.archives[a.Dir] = a
+}
+
+// removeArchives deletes the temp files of the archives.
+func (cfg *Config) removeArchives() {
+	for _, a := range cfg.archives {
+		if a.temp {
+			os.Remove(a.Path)
+		}
+	}
+	cfg.archives = nil
+}
+
+// checkSourceArchive builds the archive of src like gcloud would upload it,
+// logs a report and checks its size and content.
+func checkSourceArchive(cfg *Config, src string) error {
+	a, err := cfg.sourceArchive(src)
+	if err != nil {
+		return fmt.Errorf("can't build source archive: %s", err)
+	}
+	log.Print(a.Report())
+
+	errs := ConfigErrors{}
+	maxSize := cfg.MaxSourceSize
+	if maxSize == 0 {
+		maxSize = defaultMaxSourceSize
+	}
+	if a.Size > maxSize {
+		errs.Add("source archive of %s is %s, more than the maximum of %s", src, formatSize(a.Size), formatSize(maxSize))
+	}
+
+	if files := secretLookingFiles(a); len(files) > 0 {
+		if cfg.FailOnSecretFiles {
+			errs.Add("source %s contains files that look like secrets: %s, add them to .gcloudignore", src, strings.Join(files, ", "))
+		} else {
+			log.Printf("Warning: source %s contains files that look like secrets: %s", src, strings.Join(files, ", "))
+		}
+	}
+	return errs.Err()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{".gitignore": "*.log\n/build/\n"})
+
+	rules, err := parseIgnoreRules(dir, []string{
+		"# comment",
+		"",
+		"node_modules",
+		"*.tmp",
+		"!keep.tmp",
+		"/only-root.txt",
+		"docs/",
+		"**/fixtures/**",
+		"a/**/z.txt",
+		"file?.txt",
+		"[ab].md",
+		"#!include:.gitignore",
+		"#!include:.missing",
+	})
+	if err != nil {
+		t.Fatalf("parseIgnoreRules() err: %s", err)
+	}
+
+	for _, tst := range []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{path: "node_modules", isDir: true, expected: true},
+		{path: "sub/node_modules", isDir: true, expected: true},
+		{path: "x.tmp", expected: true},
+		{path: "sub/x.tmp", expected: true},
+		{path: "keep.tmp", expected: false},
+		{path: "only-root.txt", expected: true},
+		{path: "sub/only-root.txt", expected: false},
+		{path: "docs", isDir: true, expected: true},
+		{path: "docs", isDir: false, expected: false},
+		{path: "test/fixtures/data.json", expected: true},
+		{path: "a/z.txt", expected: true},
+		{path: "a/b/c/z.txt", expected: true},
+		{path: "file1.txt", expected: true},
+		{path: "file10.txt", expected: false},
+		{path: "a.md", expected: true},
+		{path: "c.md", expected: false},
+		{path: "debug.log", expected: true},
+		{path: "build", isDir: true, expected: true},
+		{path: "src/build", isDir: true, expected: false},
+		{path: "main.go", expected: false},
+	} {
+		if res := rules.Ignored(tst.path, tst.isDir); res != tst.expected {
+			t.Errorf("Ignored(%s, %t) got: %t   expected: %t", tst.path, tst.isDir, res, tst.expected)
+		}
+	}
+}
+
+func TestBuildSourceArchive(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"function.go":           "package function\n",
+		"go.mod":                "module example.com/function\n",
+		"big.bin":               strings.Repeat("x", 4096),
+		".git/HEAD":             "ref: refs/heads/main\n",
+		"node_modules/x/a.js":   "",
+		".gitignore":            "*.log\n",
+		"debug.log":             "",
+		"testdata/fixture.json": "{}",
+	})
+
+	b := &bytes.Buffer{}
+	a, err := buildSourceArchive(dir, b)
+	if err != nil {
+		t.Fatalf("buildSourceArchive() err: %s", err)
+	}
+
+	paths := []string{}
+	for _, f := range a.Files {
+		paths = append(paths, f.Path)
+	}
+	if expected := []string{"big.bin", "function.go", "go.mod", "testdata/fixture.json"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("unexpected files in archive, got: %#v   expected: %#v", paths, expected)
+	}
+	if a.Size != int64(b.Len()) || a.UncompressedSize != 4096+17+28+2 {
+		t.Errorf("unexpected sizes: %d (%d written) %d", a.Size, b.Len(), a.UncompressedSize)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %s", err)
+	}
+	if len(zr.File) != 4 || zr.File[0].Name != "big.bin" {
+		t.Errorf("unexpected zip content: %#v", zr.File)
+	}
+
+	if largest := a.LargestFiles(1); len(largest) != 1 || largest[0].Path != "big.bin" {
+		t.Errorf("unexpected largest files: %#v", largest)
+	}
+	if r := a.Report(); !strings.Contains(r, "4 files") || !strings.Contains(r, "4.0KB  big.bin") {
+		t.Errorf("unexpected report: %s", r)
+	}
+
+	// the archive must only depend on the content
+	if err := os.Chtimes(filepath.Join(dir, "function.go"), time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Chtimes() err: %s", err)
+	}
+	b2 := &bytes.Buffer{}
+	if _, err := buildSourceArchive(dir, b2); err != nil {
+		t.Fatalf("buildSourceArchive() err: %s", err)
+	}
+	if !bytes.Equal(b.Bytes(), b2.Bytes()) {
+		t.Errorf("archive isn't reproducible")
+	}
+
+	writeFiles(t, dir, map[string]string{".gcloudignore": "testdata/\n*.bin\n"})
+	a, err = buildSourceArchive(dir, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("buildSourceArchive() err: %s", err)
+	}
+	paths = []string{}
+	for _, f := range a.Files {
+		paths = append(paths, f.Path)
+	}
+	if expected := []string{".gcloudignore", ".git/HEAD", ".gitignore", "debug.log", "function.go", "go.mod", "node_modules/x/a.js"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("unexpected files with .gcloudignore, got: %#v", paths)
+	}
+}
+
+func TestSecretLookingFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".env":               "SECRET=1",
+		".env.production":    "SECRET=1",
+		".env.example":       "SECRET=",
+		".env.yaml":          "KEY: value",
+		"certs/server.key":   "",
+		"certs/server.pem":   "",
+		"id_rsa":             "",
+		"id_rsa.pub":         "",
+		"sa.json":            validGCPKey,
+		"config.json":        `{"type": "service_account"}`,
+		"credentials.json":   "{}",
+		"main.go":            "",
+		"environment/foo.go": "",
+	})
+
+	a, err := buildSourceArchive(dir, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("buildSourceArchive() err: %s", err)
+	}
+
+	expected := []string{".env", ".env.production", "certs/server.key", "certs/server.pem", "credentials.json", "id_rsa", "sa.json"}
+	if res := secretLookingFiles(a); !reflect.DeepEqual(res, expected) {
+		t.Errorf("secretLookingFiles() got: %#v   expected: %#v", res, expected)
+	}
+}
+
+func TestCheckSourceArchive(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.go": "package function\n", ".env": "SECRET=1"})
+
+	if err := checkSourceArchive(&Config{}, dir); err != nil {
+		t.Errorf("checkSourceArchive() failed, err: %s", err)
+	}
+
+	err := checkSourceArchive(&Config{MaxSourceSize: 10}, dir)
+	if err == nil || !strings.Contains(err.Error(), "more than the maximum of 10B") {
+		t.Errorf("expected size error, got: %v", err)
+	}
+
+	err = checkSourceArchive(&Config{FailOnSecretFiles: true}, dir)
+	if err == nil || !strings.Contains(err.Error(), "contains files that look like secrets: .env") {
+		t.Errorf("expected secret files error, got: %v", err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for in, expected := range map[string]int64{
+		"100":    100,
+		"100B":   100,
+		"512KB":  512 * 1024,
+		"512kib": 512 * 1024,
+		"100MB":  100 * 1024 * 1024,
+		"1 GB":   1024 * 1024 * 1024,
+		"2M":     2 * 1024 * 1024,
+	} {
+		if n, err := parseSize(in); err != nil || n != expected {
+			t.Errorf("parseSize(%s) got: %d   expected: %d   err: %v", in, n, expected, err)
+		}
+	}
+
+	for _, in := range []string{"", "MB", "1.5MB", "100TB", "-1"} {
+		if _, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%s) should have failed", in)
+		}
+	}
+}
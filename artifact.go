@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// label of deployed functions with the digest of the source archive
+	sourceDigestLabel = "drone-gcf-source-sha256"
+
+	// label values can't be longer than 63 characters
+	sourceDigestLabelLength = 32
+)
+
+var (
+	gcsSourceRegex = regexp.MustCompile(`^gs://[a-z0-9][a-z0-9._-]+[a-z0-9]/.+\.zip$`)
+
+	// https://cloud.google.com/sdk/gcloud/reference/functions/deploy#--source
+	csrSourceRegex = regexp.MustCompile(`^https://source\.developers\.google\.com/projects/[^/]+/repos/[^/]+(/(moveable-aliases|fixed-aliases)/[^/]+|/revisions/[^/]+)?(/paths/.*)?$`)
+)
+
+// isZipSource returns true if the source is a zip file in the workspace,
+// e.g. built by an earlier step of the pipeline.
+func isZipSource(s string) bool {
+	return !isRemoteSource(s) && strings.HasSuffix(strings.ToLower(s), ".zip")
+}
+
+// validateSourceLocation checks the format of remote sources, gcloud only
+// accepts zip files in GCS and Cloud Source Repositories urls.
+func validateSourceLocation(s string) error {
+	switch {
+	case strings.HasPrefix(s, "gs://") && !gcsSourceRegex.MatchString(s):
+		return fmt.Errorf("invalid source %s, expected gs://bucket/path/to/source.zip", s)
+	case strings.HasPrefix(s, "https://") && !csrSourceRegex.MatchString(s):
+		return fmt.Errorf("invalid source %s, expected a Cloud Source Repositories url like https://source.developers.google.com/projects/PROJECT/repos/REPO", s)
+	}
+	return nil
+}
+
+// readZipArchive describes the prebuilt zip file p like an archive that was
+// built from a source directory. The zip is used as it is and never removed.
+func readZipArchive(p string) (*SourceArchive, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, fmt.Errorf("can't read zip file %s: %s", p, err)
+	}
+	defer zr.Close()
+
+	res := &SourceArchive{Dir: p, Path: p}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		res.Files = append(res.Files, ArchiveFile{Path: f.Name, Size: int64(f.UncompressedSize64)})
+		res.UncompressedSize += int64(f.UncompressedSize64)
+	}
+
+	fh, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, fh)
+	if err != nil {
+		return nil, err
+	}
+	res.Size = n
+	res.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return res, nil
+}
+
+// rootFiles are files that have to be at the top level of a zip source,
+// keyed by the family of the runtime.
+var rootFiles = map[string][]string{
+	"python": {"main.py"},
+	"nodejs": {"package.json"},
+}
+
+// checkZipArtifact checks that the zip file in p can be deployed as source
+// of f: file names must be relative and the source files must be at the top
+// level instead of inside a directory.
+func checkZipArtifact(p string, f Function) error {
+	a, err := readZipArchive(p)
+	if err != nil {
+		return err
+	}
+
+	errs := ConfigErrors{}
+	if len(a.Files) == 0 {
+		errs.Add("zip file %s is empty", p)
+		return errs.Err()
+	}
+
+	topDirs := map[string]bool{}
+	atRoot := map[string]bool{}
+	for _, file := range a.Files {
+		name := file.Path
+		if strings.HasPrefix(name, "/") || strings.Contains(name, "\\") || strings.HasPrefix(name, "../") || strings.Contains(name, "/../") {
+			errs.Add("zip file %s contains invalid path %s", p, name)
+			continue
+		}
+		if idx := strings.Index(name, "/"); idx != -1 {
+			topDirs[name[:idx]] = true
+		} else {
+			atRoot[name] = true
+		}
+	}
+	if len(errs) > 0 {
+		return errs.Err()
+	}
+
+	if len(atRoot) == 0 && len(topDirs) == 1 {
+		for d := range topDirs {
+			errs.Add("all files of zip file %s are in directory %s/, zip the content of the directory instead", p, d)
+		}
+		return errs.Err()
+	}
+
+	family := runtimeFamily(f.Runtime)
+	for _, name := range rootFiles[family] {
+		if !atRoot[name] {
+			errs.Add("zip file %s is missing %s at the top level", p, name)
+		}
+	}
+	if family == "go" {
+		found := false
+		for name := range atRoot {
+			found = found || path.Ext(name) == ".go"
+		}
+		if !found {
+			errs.Add("zip file %s has no .go files at the top level", p)
+		}
+	}
+	return errs.Err()
+}
+
+// sourceDigestLabelValue returns the digest of a source archive shortened to
+// fit into a label value.
+func sourceDigestLabelValue(digest string) string {
+	if len(digest) > sourceDigestLabelLength {
+		return digest[:sourceDigestLabelLength]
+	}
+	return digest
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeZip creates a zip file at p with the files, given as name => content.
+func writeZip(t *testing.T, p string, files map[string]string) {
+	t.Helper()
+	fh, err := os.Create(p)
+	if err != nil {
+		t.Fatalf("Create() err: %s", err)
+	}
+	defer fh.Close()
+
+	zw := zip.NewWriter(fh)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip Create() err: %s", err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip Close() err: %s", err)
+	}
+}
+
+func TestValidateSourceLocation(t *testing.T) {
+	for s, ok := range map[string]bool{
+		"":                              true,
+		"./src":                         true,
+		"dist/function.zip":             true,
+		"gs://bucket/builds/source.zip": true,
+		"gs://bucket/":                  false,
+		"gs://bucket/source.tar.gz":     false,
+		"https://source.developers.google.com/projects/p/repos/r":                                true,
+		"https://source.developers.google.com/projects/p/repos/r/moveable-aliases/main/paths/fn": true,
+		"https://source.developers.google.com/projects/p/repos/r/revisions/abc123":               true,
+		"https://github.com/oliver006/drone-gcf":                                                 false,
+		"https://source.developers.google.com/projects/p/repos/r/branches/main":                  false,
+	} {
+		if err := validateSourceLocation(s); (err == nil) != ok {
+			t.Errorf("validateSourceLocation(%s) expected ok: %t, got err: %v", s, ok, err)
+		}
+	}
+}
+
+func TestCheckZipArtifact(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, tst := range []struct {
+		files   map[string]string
+		runtime string
+		err     string
+	}{
+		{files: map[string]string{"function.go": "package f", "go.mod": "module f"}, runtime: "go121"},
+		{files: map[string]string{"main.py": "", "requirements.txt": ""}, runtime: "python311"},
+		{files: map[string]string{"package.json": "{}", "lib/index.js": ""}, runtime: "nodejs20"},
+		{files: map[string]string{"Program.cs": ""}, runtime: "dotnet6"},
+		{files: map[string]string{}, runtime: "go121", err: "is empty"},
+		{files: map[string]string{"fn/function.go": "", "fn/go.mod": ""}, runtime: "go121", err: "are in directory fn/"},
+		{files: map[string]string{"../evil.go": "", "function.go": ""}, runtime: "go121", err: "invalid path ../evil.go"},
+		{files: map[string]string{"requirements.txt": ""}, runtime: "python311", err: "missing main.py at the top level"},
+		{files: map[string]string{"go.mod": "", "cmd/main.go": ""}, runtime: "go121", err: "no .go files at the top level"},
+	} {
+		p := filepath.Join(dir, "source.zip")
+		writeZip(t, p, tst.files)
+
+		err := checkZipArtifact(p, Function{Runtime: tst.runtime})
+		if tst.err == "" {
+			if err != nil {
+				t.Errorf("checkZipArtifact(%v) err: %s", tst.files, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tst.err) {
+			t.Errorf("checkZipArtifact(%v) expected error %q, got: %v", tst.files, tst.err, err)
+		}
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "broken.zip"), []byte("not a zip"), 0644)
+	if err := checkZipArtifact(filepath.Join(dir, "broken.zip"), Function{Runtime: "go121"}); err == nil || !strings.Contains(err.Error(), "can't read zip file") {
+		t.Errorf("expected zip error, got: %v", err)
+	}
+}
+
+func TestPreflightZipSource(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "dist"), 0755)
+	writeZip(t, filepath.Join(dir, "dist/function.zip"), map[string]string{"function.go": "package f"})
+
+	f := Function{Name: "Fn", Runtime: "go121", Trigger: "http", Source: "dist/function.zip"}
+	err := preflightFunctions(&Config{Dir: dir, Functions: Functions{f}})
+	if err == nil || !strings.Contains(err.Error(), "is a zip file, set staging_bucket") {
+		t.Errorf("expected staging bucket error, got: %v", err)
+	}
+
+	if err := preflightFunctions(&Config{Dir: dir, StagingBucket: "bucket", Functions: Functions{f}}); err != nil {
+		t.Errorf("preflightFunctions() err: %s", err)
+	}
+
+	f.Source = "dist/missing.zip"
+	err = preflightFunctions(&Config{Dir: dir, StagingBucket: "bucket", Functions: Functions{f}})
+	if err == nil || !strings.Contains(err.Error(), "doesn't exist") {
+		t.Errorf("expected missing zip error, got: %v", err)
+	}
+}
+
+func TestStageZipSource(t *testing.T) {
+	fs, srv := newFakeStorage(t)
+	dir := t.TempDir()
+	p := filepath.Join(dir, "function.zip")
+	writeZip(t, p, map[string]string{"function.go": "package f"})
+
+	b, _ := ioutil.ReadFile(p)
+	sum := sha256.Sum256(b)
+	digest := hex.EncodeToString(sum[:])
+
+	cfg := &Config{
+		Action:        "deploy",
+		Dir:           dir,
+		StagingBucket: "bucket",
+		StagingPrefix: "drone-gcf/",
+		Functions:     Functions{{Name: "Fn", Runtime: "go121", Trigger: "http", Source: "function.zip"}},
+	}
+	if err := stageSources(cfg, storageClients(NewStorageClient(srv.URL, "test-token"))); err != nil {
+		t.Fatalf("stageSources() err: %s", err)
+	}
+	cfg.removeArchives()
+
+	if _, err := os.Stat(p); err != nil {
+		t.Errorf("prebuilt zip was removed: %s", err)
+	}
+	if string(fs.objects["bucket/drone-gcf/"+digest+".zip"]) != string(b) {
+		t.Errorf("expected the zip to be uploaded as it is, got: %v", fs.objects)
+	}
+
+	f := cfg.Functions[0]
+	if f.Source != "gs://bucket/drone-gcf/"+digest+".zip" || f.sourceDigest != digest {
+		t.Errorf("unexpected source: %s   digest: %s", f.Source, f.sourceDigest)
+	}
+
+	plan, err := CreateExecutionPlan(cfg)
+	if err != nil {
+		t.Fatalf("CreateExecutionPlan() err: %s", err)
+	}
+	if args := strings.Join(plan.Steps[0], " "); !strings.Contains(args, "--update-labels drone-gcf-source-sha256="+digest[:32]) {
+		t.Errorf("missing digest label, got: %s", args)
+	}
+
+	if r := deployReport(cfg); !strings.Contains(r, "Fn  source: "+f.Source+"  sha256: "+digest) {
+		t.Errorf("unexpected deploy report: %s", r)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// detect the mode from the type of the token
+	autoAuthMode = "auto"
+	// json key of a service account
+	serviceAccountAuthMode = "service_account"
+	// Workload Identity Federation credential config
+	externalAccountAuthMode = "external_account"
+	// credentials of a user, e.g. from gcloud auth application-default login
+	authorizedUserAuthMode = "authorized_user"
+	// Application Default Credentials: the file in GOOGLE_APPLICATION_CREDENTIALS
+	// or the metadata server if it's not set
+	adcAuthMode = "adc"
+	// account of the instance the build runs on
+	metadataAuthMode = "metadata"
+
+	// location of the OIDC token of an external account given by oidc_token
+	TmpOIDCTokenFileLocation = "/tmp/oidc_token"
+)
+
+var (
+	validAuthModes = []string{autoAuthMode, serviceAccountAuthMode, externalAccountAuthMode, authorizedUserAuthMode, adcAuthMode, metadataAuthMode}
+
+	// credential types of the token and the modes they're used with
+	credentialTypes = []string{serviceAccountAuthMode, externalAccountAuthMode, authorizedUserAuthMode}
+)
+
+// credentials are the fields of a credentials json that are needed to
+// authenticate with it.
+type credentials struct {
+	Type             string                 `json:"type"`
+	ProjectID        string                 `json:"project_id"`
+	QuotaProjectID   string                 `json:"quota_project_id"`
+	Audience         string                 `json:"audience"`
+	SubjectTokenType string                 `json:"subject_token_type"`
+	CredentialSource map[string]interface{} `json:"credential_source"`
+}
+
+// resolveAuthMode sets cfg.AuthMode to the mode that is used to
+// authenticate: the type of the token unless the mode is set explicitly.
+// Application Default Credentials resolve to the type of the credentials
+// file or to the metadata server.
+func resolveAuthMode(cfg *Config, needsCredentials bool) error {
+	if cfg.AuthMode == "" {
+		cfg.AuthMode = autoAuthMode
+	}
+	if !containsString(validAuthModes, cfg.AuthMode) {
+		return fmt.Errorf("invalid auth_mode [%s], must be one of: %s", cfg.AuthMode, strings.Join(validAuthModes, ", "))
+	}
+
+	switch cfg.AuthMode {
+	case metadataAuthMode:
+		if cfg.Token != "" {
+			return fmt.Errorf("token can't be used with auth_mode %s", metadataAuthMode)
+		}
+		return nil
+
+	case adcAuthMode:
+		if cfg.Token != "" {
+			return fmt.Errorf("token can't be used with auth_mode %s, set GOOGLE_APPLICATION_CREDENTIALS", adcAuthMode)
+		}
+		p := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if p == "" {
+			cfg.AuthMode = metadataAuthMode
+			return nil
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("can't read GOOGLE_APPLICATION_CREDENTIALS: %s", err)
+		}
+		cfg.Token = string(b)
+		cfg.AuthMode = autoAuthMode
+	}
+
+	if cfg.Token == "" {
+		if needsCredentials {
+			return fmt.Errorf("Missing token")
+		}
+		return nil
+	}
+
+	c := credentials{}
+	if err := json.Unmarshal([]byte(cfg.Token), &c); err != nil {
+		return fmt.Errorf("invalid token, expected a credentials json: %s", err)
+	}
+	if !containsString(credentialTypes, c.Type) {
+		return fmt.Errorf("unsupported credential type %q in token, must be one of: %s", c.Type, strings.Join(credentialTypes, ", "))
+	}
+	if cfg.AuthMode != autoAuthMode && cfg.AuthMode != c.Type {
+		return fmt.Errorf("auth_mode is %s but the token is a %s credential", cfg.AuthMode, c.Type)
+	}
+	cfg.AuthMode = c.Type
+
+	if c.Type == externalAccountAuthMode {
+		return prepareExternalAccount(cfg, c)
+	}
+	if cfg.OIDCToken != "" {
+		return fmt.Errorf("oidc_token can only be used with external_account credentials")
+	}
+	return nil
+}
+
+// prepareExternalAccount checks the credential config of an external
+// account. If an oidc_token is given, the config is changed to read it from
+// the file it's written to.
+func prepareExternalAccount(cfg *Config, c credentials) error {
+	errs := ConfigErrors{}
+	if c.Audience == "" {
+		errs.Add("missing audience in external_account credentials")
+	}
+	if c.SubjectTokenType == "" {
+		errs.Add("missing subject_token_type in external_account credentials")
+	}
+	if cfg.OIDCToken == "" && len(c.CredentialSource) == 0 {
+		errs.Add("missing credential_source in external_account credentials, set oidc_token or the file with the token")
+	}
+	if len(errs) > 0 || cfg.OIDCToken == "" {
+		return errs.Err()
+	}
+
+	// keep all other fields of the config as they are
+	data := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(cfg.Token), &data); err != nil {
+		return err
+	}
+	data["credential_source"] = map[string]interface{}{"file": TmpOIDCTokenFileLocation}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	cfg.Token = string(b)
+	return nil
+}
+
+// writeCredentials writes the token and the OIDC token to the files gcloud
+// reads them from, the returned function removes them.
+func writeCredentials(cfg *Config) (func(), error) {
+	files := map[string]string{}
+	if cfg.AuthMode != metadataAuthMode {
+		files[TmpTokenFileLocation] = cfg.Token
+	}
+	if cfg.OIDCToken != "" {
+		files[TmpOIDCTokenFileLocation] = cfg.OIDCToken
+	}
+
+	cleanup := func() {
+		for p := range files {
+			os.Remove(p)
+		}
+	}
+	for p, content := range files {
+		if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+	return cleanup, nil
+}
+
+// authArgs returns the arguments of the gcloud command that authenticates
+// with the credentials, nil if gcloud uses the metadata server anyway.
+func authArgs(cfg *Config) []string {
+	switch cfg.AuthMode {
+	case metadataAuthMode:
+		return nil
+	case externalAccountAuthMode, authorizedUserAuthMode:
+		return []string{"auth", "login", "--cred-file", TmpTokenFileLocation}
+	}
+	return []string{"auth", "activate-service-account", "--key-file", TmpTokenFileLocation}
+}
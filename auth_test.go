@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	externalAccountToken = `{
+  "type": "external_account",
+  "audience": "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/ci/providers/drone",
+  "subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+  "token_url": "https://sts.googleapis.com/v1/token",
+  "service_account_impersonation_url": "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/deployer@my-project.iam.gserviceaccount.com:generateAccessToken",
+  "credential_source": {"file": "/drone/src/oidc-token"}
+}`
+
+	authorizedUserToken = `{
+  "type": "authorized_user",
+  "client_id": "123.apps.googleusercontent.com",
+  "client_secret": "user-secret",
+  "refresh_token": "refresh-s3cr3t",
+  "quota_project_id": "user-project"
+}`
+)
+
+func TestResolveAuthMode(t *testing.T) {
+	os.Clearenv()
+	adcFile := filepath.Join(t.TempDir(), "adc.json")
+	if err := ioutil.WriteFile(adcFile, []byte(authorizedUserToken), 0600); err != nil {
+		t.Fatalf("WriteFile() err: %s", err)
+	}
+
+	for _, tst := range []struct {
+		mode     string
+		token    string
+		adc      string
+		expected string
+		err      string
+	}{
+		{token: validGCPKey, expected: "service_account"},
+		{mode: "auto", token: externalAccountToken, expected: "external_account"},
+		{mode: "authorized_user", token: authorizedUserToken, expected: "authorized_user"},
+		{mode: "service_account", token: validGCPKey, expected: "service_account"},
+		{mode: "metadata", expected: "metadata"},
+		{mode: "adc", expected: "metadata"},
+		{mode: "adc", adc: adcFile, expected: "authorized_user"},
+		{mode: "adc", adc: adcFile + ".missing", err: "can't read GOOGLE_APPLICATION_CREDENTIALS"},
+		{mode: "adc", token: validGCPKey, err: "token can't be used with auth_mode adc"},
+		{mode: "metadata", token: validGCPKey, err: "token can't be used with auth_mode metadata"},
+		{mode: "service_account", token: externalAccountToken, err: "auth_mode is service_account but the token is a external_account credential"},
+		{mode: "keyless", token: validGCPKey, err: "invalid auth_mode [keyless]"},
+		{token: "", err: "Missing token"},
+		{token: invalidGCPKey, err: "invalid token, expected a credentials json"},
+		{token: `{"type": "impersonated_service_account"}`, err: `unsupported credential type "impersonated_service_account"`},
+		{token: `{"type": "external_account"}`, err: "missing audience in external_account credentials"},
+	} {
+		os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if tst.adc != "" {
+			os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", tst.adc)
+		}
+		cfg := &Config{AuthMode: tst.mode, Token: tst.token}
+		err := resolveAuthMode(cfg, true)
+		if tst.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.err) {
+				t.Errorf("resolveAuthMode(%s) expected %q, got: %v", tst.mode, tst.err, err)
+			}
+			continue
+		}
+		if err != nil || cfg.AuthMode != tst.expected {
+			t.Errorf("resolveAuthMode(%s) got: %s   err: %v   expected: %s", tst.mode, cfg.AuthMode, err, tst.expected)
+		}
+	}
+
+	// validating the config works without a token
+	cfg := &Config{}
+	if err := resolveAuthMode(cfg, false); err != nil || cfg.AuthMode != "auto" {
+		t.Errorf("resolveAuthMode() without token got: %s   err: %v", cfg.AuthMode, err)
+	}
+}
+
+func TestExternalAccountOIDCToken(t *testing.T) {
+	cfg := &Config{Token: externalAccountToken, OIDCToken: "eyJhbGciOi.oidc.token"}
+	if err := resolveAuthMode(cfg, true); err != nil {
+		t.Fatalf("resolveAuthMode() err: %s", err)
+	}
+
+	data := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(cfg.Token), &data); err != nil {
+		t.Fatalf("invalid token: %s", err)
+	}
+	if !reflect.DeepEqual(data["credential_source"], map[string]interface{}{"file": TmpOIDCTokenFileLocation}) || data["audience"] == "" || data["service_account_impersonation_url"] == "" {
+		t.Errorf("unexpected credential config: %s", cfg.Token)
+	}
+
+	cfg = &Config{Token: `{"type": "external_account", "audience": "aud", "subject_token_type": "jwt"}`}
+	if err := resolveAuthMode(cfg, true); err == nil || !strings.Contains(err.Error(), "missing credential_source") {
+		t.Errorf("expected a missing credential_source error, got: %v", err)
+	}
+
+	cfg = &Config{Token: validGCPKey, OIDCToken: "token"}
+	if err := resolveAuthMode(cfg, true); err == nil || !strings.Contains(err.Error(), "oidc_token can only be used with external_account credentials") {
+		t.Errorf("expected an oidc_token error, got: %v", err)
+	}
+}
+
+func TestAuthArgs(t *testing.T) {
+	for mode, expected := range map[string][]string{
+		"service_account":  {"auth", "activate-service-account", "--key-file", TmpTokenFileLocation},
+		"external_account": {"auth", "login", "--cred-file", TmpTokenFileLocation},
+		"authorized_user":  {"auth", "login", "--cred-file", TmpTokenFileLocation},
+		"metadata":         nil,
+	} {
+		if res := authArgs(&Config{AuthMode: mode}); !reflect.DeepEqual(res, expected) {
+			t.Errorf("authArgs(%s) got: %#v   expected: %#v", mode, res, expected)
+		}
+	}
+}
+
+func TestWriteCredentials(t *testing.T) {
+	cfg := &Config{AuthMode: "external_account", Token: externalAccountToken, OIDCToken: "oidc"}
+	remove, err := writeCredentials(cfg)
+	if err != nil {
+		t.Fatalf("writeCredentials() err: %s", err)
+	}
+	for p, expected := range map[string]string{TmpTokenFileLocation: externalAccountToken, TmpOIDCTokenFileLocation: "oidc"} {
+		if b, err := ioutil.ReadFile(p); err != nil || string(b) != expected {
+			t.Errorf("%s got: %q   err: %v", p, b, err)
+		}
+	}
+	remove()
+	for _, p := range []string{TmpTokenFileLocation, TmpOIDCTokenFileLocation} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", p)
+		}
+	}
+}
+
+func TestParseConfigAuthMode(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("PLUGIN_ACTION", "deploy")
+	os.Setenv("PLUGIN_AUTH_MODE", "metadata")
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"F":[{"trigger":"http","runtime":"go121"}]}]`)
+
+	if _, err := parseConfig(); err == nil || !strings.Contains(err.Error(), "project id not found in token or param") {
+		t.Errorf("expected a missing project error, got: %v", err)
+	}
+
+	os.Setenv("PLUGIN_PROJECT", "my-project")
+	cfg, err := parseConfig()
+	if err != nil || cfg.AuthMode != "metadata" || cfg.Project != "my-project" {
+		t.Errorf("parseConfig() got: %#v   err: %v", cfg, err)
+	}
+
+	os.Setenv("PLUGIN_AUTH_MODE", "")
+	os.Unsetenv("PLUGIN_PROJECT")
+	os.Setenv("PLUGIN_TOKEN", authorizedUserToken)
+	cfg, err = parseConfig()
+	if err != nil || cfg.AuthMode != "authorized_user" || cfg.Project != "user-project" {
+		t.Errorf("parseConfig() got: %#v   err: %v", cfg, err)
+	}
+	for _, v := range []string{"user-secret", "refresh-s3cr3t"} {
+		if !containsString(cfg.secretValues(), v) {
+			t.Errorf("expected %s to be masked", v)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// BuildConfig configures how Cloud Build builds the function.
+type BuildConfig struct {
+	Environment      []map[string]string `json:"environment"`
+	WorkerPool       string              `json:"worker_pool"`
+	DockerRegistry   string              `json:"docker_registry"`
+	DockerRepository string              `json:"docker_repository"`
+	KMSKey           string              `json:"kms_key"`
+}
+
+var (
+	validDockerRegistries = []string{"artifact-registry", "container-registry"}
+
+	workerPoolRegex       = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/workerPools/[^/]+$`)
+	dockerRepositoryRegex = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/repositories/[^/]+$`)
+	kmsKeyRegex           = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+)
+
+// descriptions of the settings of the build section
+var buildSettingDescriptions = map[string]string{
+	"environment":       "Environment variables available while building the function, e.g. GOPRIVATE.",
+	"worker_pool":       "Cloud Build worker pool, projects/PROJECT/locations/REGION/workerPools/POOL.",
+	"docker_registry":   "Registry that stores the image of the function.",
+	"docker_repository": "Artifact Registry repository, projects/PROJECT/locations/REGION/repositories/REPO.",
+	"kms_key":           "KMS key used to encrypt the function, requires docker_repository.",
+}
+
+// validateBuild checks the build section of a function.
+func validateBuild(b *BuildConfig) ConfigErrors {
+	errs := ConfigErrors{}
+	if b == nil {
+		return errs
+	}
+
+	if b.WorkerPool != "" && !workerPoolRegex.MatchString(b.WorkerPool) {
+		errs.Add("invalid build worker_pool %q, expected projects/PROJECT/locations/REGION/workerPools/POOL", b.WorkerPool)
+	}
+	if b.DockerRepository != "" && !dockerRepositoryRegex.MatchString(b.DockerRepository) {
+		errs.Add("invalid build docker_repository %q, expected projects/PROJECT/locations/REGION/repositories/REPO", b.DockerRepository)
+	}
+	if b.DockerRepository != "" && b.DockerRegistry == "container-registry" {
+		errs.Add("build docker_repository requires docker_registry artifact-registry")
+	}
+	if b.KMSKey != "" {
+		if !kmsKeyRegex.MatchString(b.KMSKey) {
+			errs.Add("invalid build kms_key %q, expected projects/PROJECT/locations/REGION/keyRings/RING/cryptoKeys/KEY", b.KMSKey)
+		}
+		if b.DockerRepository == "" {
+			errs.Add("build kms_key requires docker_repository")
+		}
+	}
+	for _, env := range b.Environment {
+		for _, k := range sortedKeys(env) {
+			if k == "" || strings.Contains(k, "=") {
+				errs.Add("invalid build environment variable %q", k)
+			}
+		}
+	}
+	return errs
+}
+
+// buildArgs returns the gcloud arguments for the build section of f, the
+// build env secrets are added to the build environment of every function.
+func buildArgs(cfg *Config, f Function) []string {
+	args := []string{}
+	b := f.Build
+	if b == nil {
+		b = &BuildConfig{}
+	}
+
+	env := make([]string, len(cfg.BuildEnvSecrets))
+	copy(env, cfg.BuildEnvSecrets)
+	for _, m := range b.Environment {
+		for _, k := range sortedKeys(m) {
+			env = append(env, fmt.Sprintf(`%s=%s`, k, m[k]))
+		}
+	}
+	if len(env) > 0 {
+		args = append(args, "--set-build-env-vars", "^"+f.EnvironmentDelimiter+"^"+strings.Join(env, f.EnvironmentDelimiter))
+	}
+
+	if b.WorkerPool != "" {
+		args = append(args, "--build-worker-pool", b.WorkerPool)
+	}
+	if b.DockerRegistry != "" {
+		args = append(args, "--docker-registry", b.DockerRegistry)
+	}
+	if b.DockerRepository != "" {
+		args = append(args, "--docker-repository", b.DockerRepository)
+	}
+	if b.KMSKey != "" {
+		args = append(args, "--kms-key", b.KMSKey)
+	}
+	return args
+}
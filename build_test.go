@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateBuild(t *testing.T) {
+	valid := &BuildConfig{
+		Environment:      []map[string]string{{"GOPRIVATE": "github.com/org/*"}},
+		WorkerPool:       "projects/p/locations/us-central1/workerPools/pool",
+		DockerRegistry:   "artifact-registry",
+		DockerRepository: "projects/p/locations/us-central1/repositories/functions",
+		KMSKey:           "projects/p/locations/us-central1/keyRings/ring/cryptoKeys/key",
+	}
+	if errs := validateBuild(valid); len(errs) > 0 {
+		t.Errorf("validateBuild() err: %s", errs)
+	}
+	if errs := validateBuild(nil); len(errs) > 0 {
+		t.Errorf("validateBuild(nil) err: %s", errs)
+	}
+
+	errs := validateBuild(&BuildConfig{
+		WorkerPool:       "pool",
+		DockerRegistry:   "container-registry",
+		DockerRepository: "functions",
+		KMSKey:           "key",
+	})
+	for _, e := range []string{`invalid build worker_pool "pool"`, `invalid build docker_repository "functions"`, "docker_repository requires docker_registry artifact-registry", `invalid build kms_key "key"`} {
+		if !strings.Contains(errs.Error(), e) {
+			t.Errorf("expected %q in errors, got: %s", e, errs)
+		}
+	}
+
+	errs = validateBuild(&BuildConfig{KMSKey: valid.KMSKey})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "kms_key requires docker_repository") {
+		t.Errorf("expected docker_repository error, got: %v", errs)
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	cfg := &Config{BuildEnvSecrets: []string{"NPM_TOKEN=s3cr3t"}}
+	f := Function{
+		EnvironmentDelimiter: ":|:",
+		Build: &BuildConfig{
+			Environment:      []map[string]string{{"GOPRIVATE": "github.com/org/*,github.com/other/*", "GOFLAGS": "-mod=mod"}},
+			DockerRegistry:   "artifact-registry",
+			DockerRepository: "projects/p/locations/l/repositories/r",
+		},
+	}
+	expected := []string{
+		"--set-build-env-vars", "^:|:^NPM_TOKEN=s3cr3t:|:GOFLAGS=-mod=mod:|:GOPRIVATE=github.com/org/*,github.com/other/*",
+		"--docker-registry", "artifact-registry",
+		"--docker-repository", "projects/p/locations/l/repositories/r",
+	}
+	if args := buildArgs(cfg, f); !reflect.DeepEqual(args, expected) {
+		t.Errorf("buildArgs() got: %#v   expected: %#v", args, expected)
+	}
+
+	if args := buildArgs(&Config{}, Function{}); len(args) != 0 {
+		t.Errorf("expected no build args, got: %#v", args)
+	}
+}
+
+func TestParseBuildConfig(t *testing.T) {
+	functions, err := parseFunctions(`[{"F":[{"trigger":"http","build":{"environment":[{"GOPRIVATE":"x"}],"worker_pool":"projects/p/locations/l/workerPools/w"}}]}]`, "go121", nil)
+	if err != nil {
+		t.Fatalf("parseFunctions() err: %s", err)
+	}
+	if b := functions[0].Build; b == nil || b.WorkerPool != "projects/p/locations/l/workerPools/w" || b.Environment[0]["GOPRIVATE"] != "x" {
+		t.Errorf("unexpected build config: %#v", b)
+	}
+
+	_, err = parseFunctions(`[{"F":[{"trigger":"http","build":{"worker_poll":"x","docker_registry":"docker-hub"}}]}]`, "go121", nil)
+	for _, e := range []string{`unknown setting "build.worker_poll", did you mean "worker_pool"?`, `invalid value "docker-hub" for setting "build.docker_registry"`} {
+		if err == nil || !strings.Contains(err.Error(), e) {
+			t.Errorf("expected %q in error, got: %v", e, err)
+		}
+	}
+}
+
+func TestBuildEnvSecretsAreMasked(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("PLUGIN_ACTION", "deploy")
+	os.Setenv("PLUGIN_TOKEN", validGCPKey)
+	os.Setenv("PLUGIN_BUILD_ENV_SECRET_NPM_TOKEN", "npm-s3cr3t")
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"F":[{"trigger":"http","runtime":"nodejs18"}]}]`)
+
+	cfg, err := parseConfig()
+	if err != nil {
+		t.Fatalf("parseConfig() err: %s", err)
+	}
+	if !reflect.DeepEqual(cfg.BuildEnvSecrets, []string{"NPM_TOKEN=npm-s3cr3t"}) {
+		t.Errorf("unexpected build env secrets: %#v", cfg.BuildEnvSecrets)
+	}
+
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	e := NewEnv("/tmp", nil, &bytes.Buffer{}, &bytes.Buffer{}, true, true)
+	e.Mask(cfg.secretValues()...)
+	plan, err := CreateExecutionPlan(cfg)
+	if err != nil {
+		t.Fatalf("CreateExecutionPlan() err: %s", err)
+	}
+	if err := ExecutePlan(e, plan); err != nil {
+		t.Fatalf("ExecutePlan() err: %s", err)
+	}
+
+	if strings.Contains(buf.String(), "npm-s3cr3t") || !strings.Contains(buf.String(), "NPM_TOKEN=****") {
+		t.Errorf("build env secret not masked, got: %s", buf.String())
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+// now is replaced in tests, the lifecycle of the runtimes depends on it.
+var now = time.Now
+
+// RuntimeInfo describes the lifecycle of a Cloud Functions runtime. Dates are
+// formatted as 2006-01-02 and empty if not announced yet.
+type RuntimeInfo struct {
+	Name           string
+	Deprecated     string
+	Decommissioned string
+	Gen1           bool
+	Gen2           bool
+}
+
+// runtimeCatalog lists the runtimes with their lifecycle as published in
+// https://cloud.google.com/functions/docs/runtime-support, sorted by family
+// and version. Use refresh_runtimes to update it from gcloud.
+var runtimeCatalog = []RuntimeInfo{
+	{Name: "nodejs10", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true},
+	{Name: "nodejs12", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true, Gen2: true},
+	{Name: "nodejs14", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true, Gen2: true},
+	{Name: "nodejs16", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true, Gen2: true},
+	{Name: "nodejs18", Deprecated: "2025-04-30", Decommissioned: "2025-10-31", Gen1: true, Gen2: true},
+	{Name: "nodejs20", Deprecated: "2026-04-30", Decommissioned: "2026-10-30", Gen1: true, Gen2: true},
+	{Name: "nodejs22", Deprecated: "2027-04-30", Decommissioned: "2027-10-31", Gen2: true},
+	{Name: "python37", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true},
+	{Name: "python38", Deprecated: "2024-10-14", Decommissioned: "2025-04-14", Gen1: true, Gen2: true},
+	{Name: "python39", Deprecated: "2025-10-05", Decommissioned: "2026-04-05", Gen1: true, Gen2: true},
+	{Name: "python310", Deprecated: "2026-10-04", Decommissioned: "2027-04-04", Gen1: true, Gen2: true},
+	{Name: "python311", Deprecated: "2027-10-24", Decommissioned: "2028-04-24", Gen1: true, Gen2: true},
+	{Name: "python312", Deprecated: "2028-10-02", Decommissioned: "2029-04-02", Gen1: true, Gen2: true},
+	{Name: "go111", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true},
+	{Name: "go113", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true},
+	{Name: "go116", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true, Gen2: true},
+	{Name: "go118", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true, Gen2: true},
+	{Name: "go119", Deprecated: "2024-04-30", Decommissioned: "2025-01-30", Gen1: true, Gen2: true},
+	{Name: "go120", Deprecated: "2024-05-01", Decommissioned: "2025-01-30", Gen1: true, Gen2: true},
+	{Name: "go121", Deprecated: "2025-05-01", Decommissioned: "2026-01-31", Gen1: true, Gen2: true},
+	{Name: "go122", Deprecated: "2026-02-11", Decommissioned: "2026-08-11", Gen2: true},
+	{Name: "go123", Deprecated: "2026-08-13", Decommissioned: "2027-02-13", Gen2: true},
+	{Name: "go124", Deprecated: "2027-02-11", Decommissioned: "2027-08-11", Gen2: true},
+	{Name: "go125", Deprecated: "2027-08-12", Decommissioned: "2028-02-12", Gen2: true},
+	{Name: "java11", Deprecated: "2027-10-31", Decommissioned: "2028-04-30", Gen1: true, Gen2: true},
+	{Name: "java17", Deprecated: "2029-10-31", Decommissioned: "2030-04-30", Gen1: true, Gen2: true},
+	{Name: "java21", Deprecated: "2031-10-31", Decommissioned: "2032-04-30", Gen2: true},
+	{Name: "dotnet3", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true, Gen2: true},
+	{Name: "dotnet6", Deprecated: "2024-11-12", Decommissioned: "2025-05-12", Gen1: true, Gen2: true},
+	{Name: "dotnet8", Deprecated: "2026-11-10", Decommissioned: "2027-05-10", Gen2: true},
+	{Name: "ruby26", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true},
+	{Name: "ruby27", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true, Gen2: true},
+	{Name: "ruby30", Deprecated: "2024-03-31", Decommissioned: "2025-03-31", Gen1: true, Gen2: true},
+	{Name: "ruby32", Deprecated: "2026-03-31", Decommissioned: "2026-09-30", Gen1: true, Gen2: true},
+	{Name: "ruby33", Deprecated: "2027-03-31", Decommissioned: "2027-09-30", Gen2: true},
+	{Name: "php74", Deprecated: "2024-01-30", Decommissioned: "2025-01-30", Gen1: true, Gen2: true},
+	{Name: "php81", Deprecated: "2024-11-25", Decommissioned: "2025-05-25", Gen1: true, Gen2: true},
+	{Name: "php82", Deprecated: "2026-12-31", Decommissioned: "2027-06-30", Gen1: true, Gen2: true},
+	{Name: "php83", Deprecated: "2027-12-31", Decommissioned: "2028-06-30", Gen2: true},
+}
+
+func catalogRuntimeNames(catalog []RuntimeInfo) []string {
+	res := make([]string, 0, len(catalog))
+	for _, r := range catalog {
+		res = append(res, r.Name)
+	}
+	return res
+}
+
+// deployableRuntimes returns the runtimes that weren't decommissioned yet.
+func deployableRuntimes() []string {
+	res := []string{}
+	for _, ri := range runtimeCatalog {
+		if !ri.IsDecommissioned(now()) {
+			res = append(res, ri.Name)
+		}
+	}
+	return res
+}
+
+// runtimeInfo returns the catalog entry of the runtime r.
+func runtimeInfo(r string) (RuntimeInfo, bool) {
+	for _, ri := range runtimeCatalog {
+		if ri.Name == r {
+			return ri, true
+		}
+	}
+	return RuntimeInfo{}, false
+}
+
+// reached returns true if the date isn't empty and has been reached at t.
+func reached(date string, t time.Time) bool {
+	if date == "" {
+		return false
+	}
+	d, err := time.Parse(dateFormat, date)
+	return err == nil && !t.Before(d)
+}
+
+func (ri RuntimeInfo) IsDeprecated(t time.Time) bool {
+	return reached(ri.Deprecated, t)
+}
+
+func (ri RuntimeInfo) IsDecommissioned(t time.Time) bool {
+	return reached(ri.Decommissioned, t)
+}
+
+// defaultRuntime returns the Go runtime used for functions without a
+// runtime: the newest one of the generation that isn't deprecated, or if
+// there's none, the newest one that isn't decommissioned yet.
+func defaultRuntime(gen2 bool) string {
+	res, best, rank := "", version{}, -1
+	for _, ri := range runtimeCatalog {
+		v, ok := runtimeVersion(ri.Name)
+		if runtimeFamily(ri.Name) != "go" || !ok || (gen2 && !ri.Gen2) || (!gen2 && !ri.Gen1) {
+			continue
+		}
+		r := 0
+		if !ri.IsDecommissioned(now()) {
+			r++
+		}
+		if !ri.IsDeprecated(now()) {
+			r++
+		}
+		if r > rank || (r == rank && v.compare(best) > 0) {
+			res, best, rank = ri.Name, v, r
+		}
+	}
+	if res == "" {
+		// the catalog has no Go runtime for the generation at all
+		return validRuntimes[len(validRuntimes)-1]
+	}
+	return res
+}
+
+// replacementRuntime returns the newest runtime of the same family that
+// isn't deprecated, to be suggested instead of r.
+func replacementRuntime(r string) string {
+	res, best := "", version{}
+	for _, ri := range runtimeCatalog {
+		v, ok := runtimeVersion(ri.Name)
+		if runtimeFamily(ri.Name) != runtimeFamily(r) || !ok || ri.IsDeprecated(now()) {
+			continue
+		}
+		if res == "" || v.compare(best) > 0 {
+			res, best = ri.Name, v
+		}
+	}
+	return res
+}
+
+// isNewerRuntime returns true for runtimes that aren't in the catalog but
+// are newer versions of a family in it, e.g. runtimes released after the
+// plugin. They are passed to gcloud without checking their lifecycle.
+func isNewerRuntime(r string) bool {
+	v, ok := runtimeVersion(r)
+	if !ok || isValidRuntime(r) {
+		return false
+	}
+	found := false
+	for _, ri := range runtimeCatalog {
+		cv, ok := runtimeVersion(ri.Name)
+		if !ok || runtimeFamily(ri.Name) != runtimeFamily(r) {
+			continue
+		}
+		if v.compare(cv) <= 0 {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// checkRuntimeLifecycle fails for runtimes that were decommissioned, these
+// can't be deployed anymore.
+func checkRuntimeLifecycle(r string) error {
+	ri, ok := runtimeInfo(r)
+	if !ok || !ri.IsDecommissioned(now()) {
+		return nil
+	}
+	msg := fmt.Sprintf("runtime %s was decommissioned on %s", r, ri.Decommissioned)
+	if repl := replacementRuntime(r); repl != "" {
+		msg += ", use " + repl
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// runtimeDeprecationWarning returns a warning for deprecated runtimes which
+// can still be deployed but not for much longer.
+func runtimeDeprecationWarning(r string) string {
+	ri, ok := runtimeInfo(r)
+	if !ok || !ri.IsDeprecated(now()) || ri.IsDecommissioned(now()) {
+		return ""
+	}
+	msg := fmt.Sprintf("runtime %s is deprecated since %s", r, ri.Deprecated)
+	if ri.Decommissioned != "" {
+		msg += fmt.Sprintf(" and will be decommissioned on %s", ri.Decommissioned)
+	}
+	if repl := replacementRuntime(r); repl != "" {
+		msg += ", switch to " + repl
+	}
+	return msg
+}
+
+// gcloudRuntime is a runtime as listed by
+// "gcloud functions runtimes list --format=json".
+type gcloudRuntime struct {
+	Name             string     `json:"name"`
+	Stage            string     `json:"stage"`
+	Environments     []string   `json:"environments"`
+	Environment      string     `json:"environment"`
+	DeprecationDate  gcloudDate `json:"deprecationDate"`
+	DecommissionDate gcloudDate `json:"decommissionDate"`
+}
+
+// gcloudDate is either a date string or an object with year, month and day.
+type gcloudDate string
+
+func (d *gcloudDate) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*d = gcloudDate(s)
+		return nil
+	}
+	parts := struct {
+		Year  int `json:"year"`
+		Month int `json:"month"`
+		Day   int `json:"day"`
+	}{}
+	if err := json.Unmarshal(b, &parts); err != nil {
+		return err
+	}
+	if parts.Year != 0 {
+		*d = gcloudDate(fmt.Sprintf("%04d-%02d-%02d", parts.Year, parts.Month, parts.Day))
+	}
+	return nil
+}
+
+// updateRuntimeCatalog updates the lifecycle of the runtimes from the output
+// of gcloud, runtimes can be listed once per environment. Runtimes that
+// aren't in the catalog yet are added.
+func updateRuntimeCatalog(out []byte) (int, error) {
+	listed := []gcloudRuntime{}
+	if err := json.Unmarshal(out, &listed); err != nil {
+		return 0, fmt.Errorf("can't parse runtimes: %s", err)
+	}
+
+	envs := map[string]map[string]bool{}
+	updated := map[string]bool{}
+	for _, lr := range listed {
+		idx := -1
+		for i, ri := range runtimeCatalog {
+			if ri.Name == lr.Name {
+				idx = i
+			}
+		}
+		if idx == -1 {
+			idx = addRuntime(RuntimeInfo{Name: lr.Name})
+		}
+
+		ri := &runtimeCatalog[idx]
+		if d := string(lr.DeprecationDate); d != "" {
+			ri.Deprecated = d
+		}
+		if d := string(lr.DecommissionDate); d != "" {
+			ri.Decommissioned = d
+		}
+
+		if envs[lr.Name] == nil {
+			envs[lr.Name] = map[string]bool{}
+		}
+		for _, e := range append(lr.Environments, lr.Environment) {
+			envs[lr.Name][strings.ToUpper(e)] = true
+		}
+		updated[lr.Name] = true
+	}
+
+	for name, e := range envs {
+		if !e["GEN_1"] && !e["GEN_2"] {
+			continue
+		}
+		for i := range runtimeCatalog {
+			if runtimeCatalog[i].Name == name {
+				runtimeCatalog[i].Gen1, runtimeCatalog[i].Gen2 = e["GEN_1"], e["GEN_2"]
+			}
+		}
+	}
+	validRuntimes = catalogRuntimeNames(runtimeCatalog)
+	return len(updated), nil
+}
+
+// addRuntime adds ri to the catalog after the older runtimes of its family
+// and returns its index.
+func addRuntime(ri RuntimeInfo) int {
+	idx := -1
+	v, _ := runtimeVersion(ri.Name)
+	for i, c := range runtimeCatalog {
+		if runtimeFamily(c.Name) != runtimeFamily(ri.Name) {
+			continue
+		}
+		if idx == -1 {
+			idx = i
+		}
+		if cv, ok := runtimeVersion(c.Name); ok && cv.compare(v) < 0 {
+			idx = i + 1
+		}
+	}
+	if idx == -1 {
+		idx = len(runtimeCatalog)
+	}
+	runtimeCatalog = append(runtimeCatalog, RuntimeInfo{})
+	copy(runtimeCatalog[idx+1:], runtimeCatalog[idx:])
+	runtimeCatalog[idx] = ri
+	return idx
+}
+
+// refreshRuntimeCatalog updates the catalog with the runtimes that gcloud
+// lists for the region, args are added to the gcloud command.
+func refreshRuntimeCatalog(e *Env, region string, args ...string) error {
+	args = append([]string{"functions", "runtimes", "list", "--region", region, "--format=json"}, args...)
+	out, err := e.Output("gcloud", args...)
+	if err != nil {
+		return fmt.Errorf("can't list runtimes: %s", err)
+	}
+	if out == "" {
+		// dry run
+		return nil
+	}
+	n, err := updateRuntimeCatalog([]byte(out))
+	if err != nil {
+		return err
+	}
+	log.Printf("Updated the lifecycle of %d runtime(s) from gcloud", n)
+	return nil
+}
+
+// runtimeCatalogRegion returns the region used to list the runtimes, the
+// first region of the functions or gcloud's default.
+func runtimeCatalogRegion(functions Functions) string {
+	for _, f := range functions {
+		if f.Region != "" {
+			return f.Region
+		}
+	}
+	return "us-central1"
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setNow changes the date of the runtime lifecycle until the test is done.
+func setNow(t *testing.T, date string) {
+	t.Helper()
+	d, err := time.Parse(dateFormat, date)
+	if err != nil {
+		t.Fatalf("time.Parse() err: %s", err)
+	}
+	now = func() time.Time { return d }
+	t.Cleanup(func() { now = func() time.Time { return testDate } })
+}
+
+func TestRuntimeCatalog(t *testing.T) {
+	seen := map[string]bool{}
+	for _, ri := range runtimeCatalog {
+		if seen[ri.Name] {
+			t.Errorf("duplicate runtime: %s", ri.Name)
+		}
+		seen[ri.Name] = true
+
+		if _, ok := runtimeVersion(ri.Name); !ok {
+			t.Errorf("can't parse version of runtime %s", ri.Name)
+		}
+		if !ri.Gen1 && !ri.Gen2 {
+			t.Errorf("runtime %s isn't available for any generation", ri.Name)
+		}
+		for _, d := range []string{ri.Deprecated, ri.Decommissioned} {
+			if _, err := time.Parse(dateFormat, d); d != "" && err != nil {
+				t.Errorf("invalid date %q for runtime %s", d, ri.Name)
+			}
+		}
+	}
+
+	for _, r := range []string{"nodejs22", "python312", "go122", "java21"} {
+		if !isValidRuntime(r) {
+			t.Errorf("runtime %s is missing", r)
+		}
+	}
+}
+
+func TestRuntimeLifecycle(t *testing.T) {
+	setNow(t, "2026-10-18")
+
+	if r := defaultRuntime(true); r != "go125" {
+		t.Errorf("defaultRuntime(gen2) got: %s", r)
+	}
+	// no gen1 Go runtime is deployable anymore, the newest one is used
+	if r := defaultRuntime(false); r != "go121" {
+		t.Errorf("defaultRuntime(gen1) got: %s", r)
+	}
+
+	err := checkRuntimeLifecycle("go111")
+	if err == nil || err.Error() != "runtime go111 was decommissioned on 2025-01-30, use go125" {
+		t.Errorf("checkRuntimeLifecycle(go111) got: %v", err)
+	}
+	if err := checkRuntimeLifecycle("nodejs20"); err != nil {
+		t.Errorf("checkRuntimeLifecycle(nodejs20) err: %s", err)
+	}
+
+	w := runtimeDeprecationWarning("nodejs20")
+	if w != "runtime nodejs20 is deprecated since 2026-04-30 and will be decommissioned on 2026-10-30, switch to nodejs22" {
+		t.Errorf("runtimeDeprecationWarning(nodejs20) got: %s", w)
+	}
+	for _, r := range []string{"nodejs22", "go111", "unknown"} {
+		if w := runtimeDeprecationWarning(r); w != "" {
+			t.Errorf("runtimeDeprecationWarning(%s) got: %s", r, w)
+		}
+	}
+
+	// decommissioned runtimes aren't detected anymore
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": "module f\n\ngo 1.16\n"})
+	if r, _, err := detectRuntime(dir); err != nil || r != "go123" {
+		t.Errorf("detectRuntime() got: %s   err: %v", r, err)
+	}
+
+	os.Clearenv()
+	os.Setenv("PLUGIN_ACTION", "deploy")
+	os.Setenv("PLUGIN_TOKEN", validGCPKey)
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"F":[{"trigger":"http","runtime":"python37"}]}]`)
+	if _, err := parseConfig(); err == nil || !strings.Contains(err.Error(), "function F: runtime python37 was decommissioned") {
+		t.Errorf("expected decommissioned error, got: %v", err)
+	}
+	// the lifecycle is checked once the catalog is refreshed from gcloud
+	os.Setenv("PLUGIN_REFRESH_RUNTIMES", "true")
+	if _, err := parseConfig(); err != nil {
+		t.Errorf("parseConfig() with refresh_runtimes err: %s", err)
+	}
+}
+
+func TestNewerRuntimes(t *testing.T) {
+	setNow(t, "2026-10-18")
+
+	for _, r := range []string{"python313", "nodejs24", "go126", "java25"} {
+		if err := validateRuntime(r); err != nil {
+			t.Errorf("validateRuntime(%s) err: %s", r, err)
+		}
+		if w := functionWarnings(Function{Name: "F", Runtime: r, Trigger: "http", Gen2: true}); len(w) != 1 || !strings.Contains(w[0], "isn't known to the plugin yet") {
+			t.Errorf("functionWarnings(%s) got: %v", r, w)
+		}
+	}
+	for _, r := range []string{"python36", "go110", "cobol85", "lol123", "nodejs"} {
+		if err := validateRuntime(r); err == nil {
+			t.Errorf("validateRuntime(%s) should have failed", r)
+		}
+	}
+	if err := validateRuntime("python311x"); err == nil || !strings.Contains(err.Error(), `did you mean "python311"?`) {
+		t.Errorf("validateRuntime(python311x) got: %v", err)
+	}
+
+	os.Clearenv()
+	os.Setenv("PLUGIN_ACTION", "deploy")
+	os.Setenv("PLUGIN_TOKEN", validGCPKey)
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"F":[{"trigger":"http","runtime":"python313","gen2":true}]}]`)
+	if _, err := parseConfig(); err != nil {
+		t.Errorf("parseConfig() err: %s", err)
+	}
+}
+
+func TestDefaultRuntimeForGeneration(t *testing.T) {
+	now = time.Now
+	defer func() { now = func() time.Time { return testDate } }()
+
+	for _, gen2 := range []bool{false, true} {
+		r := defaultRuntime(gen2)
+		ri, ok := runtimeInfo(r)
+		if !ok || (gen2 && !ri.Gen2) || (!gen2 && !ri.Gen1) {
+			t.Errorf("defaultRuntime(%t) got: %s", gen2, r)
+		}
+	}
+
+	for _, fs := range []string{`[{"Hello":[{"trigger":"http"}]}]`, `[{"Hello":[{"trigger":"http","gen2":true}]}]`} {
+		os.Clearenv()
+		os.Setenv("PLUGIN_ACTION", "deploy")
+		os.Setenv("PLUGIN_TOKEN", validGCPKey)
+		os.Setenv("PLUGIN_FUNCTIONS", fs)
+		if _, err := parseConfig(); err != nil && strings.Contains(err.Error(), "only available for gen2") {
+			t.Errorf("parseConfig(%s) err: %s", fs, err)
+		}
+	}
+}
+
+func TestUpdateRuntimeCatalog(t *testing.T) {
+	saved := make([]RuntimeInfo, len(runtimeCatalog))
+	copy(saved, runtimeCatalog)
+	defer func() {
+		runtimeCatalog = saved
+		validRuntimes = catalogRuntimeNames(saved)
+	}()
+
+	out := `[
+  {"name": "nodejs20", "stage": "DEPRECATED", "environments": ["GEN_1", "GEN_2"], "deprecationDate": "2026-05-01", "decommissionDate": "2026-11-01"},
+  {"name": "go125", "stage": "GA", "environment": "GEN_2", "deprecationDate": {"year": 2027, "month": 9, "day": 1}},
+  {"name": "go125", "stage": "GA", "environment": "GEN_1"},
+  {"name": "python313", "stage": "GA", "environments": ["GEN_1", "GEN_2"], "deprecationDate": "2029-10-10"},
+  {"name": "cobol85", "stage": "GA", "environments": ["GEN_2"]}
+]`
+	n, err := updateRuntimeCatalog([]byte(out))
+	if err != nil || n != 4 {
+		t.Fatalf("updateRuntimeCatalog() got: %d   err: %v", n, err)
+	}
+
+	if ri, _ := runtimeInfo("nodejs20"); ri.Deprecated != "2026-05-01" || ri.Decommissioned != "2026-11-01" || !ri.Gen1 || !ri.Gen2 {
+		t.Errorf("unexpected nodejs20: %#v", ri)
+	}
+	if ri, _ := runtimeInfo("go125"); ri.Deprecated != "2027-09-01" || ri.Decommissioned != "2028-02-12" || !ri.Gen1 || !ri.Gen2 {
+		t.Errorf("unexpected go125: %#v", ri)
+	}
+	// runtimes released after the plugin are added
+	if ri, _ := runtimeInfo("python313"); ri.Deprecated != "2029-10-10" || !ri.Gen1 || !ri.Gen2 {
+		t.Errorf("unexpected python313: %#v", ri)
+	}
+	if ri, _ := runtimeInfo("cobol85"); !ri.Gen2 || !isValidRuntime("cobol85") {
+		t.Errorf("unexpected cobol85: %#v", ri)
+	}
+	for i, ri := range runtimeCatalog {
+		if ri.Name == "python313" && runtimeCatalog[i-1].Name != "python312" {
+			t.Errorf("python313 should follow python312, got: %v", catalogRuntimeNames(runtimeCatalog))
+		}
+	}
+
+	if _, err := updateRuntimeCatalog([]byte("Listed 0 items.")); err == nil {
+		t.Errorf("expected error for invalid output")
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// runtimeFamily returns the language of a runtime, e.g. "go" for "go121".
+func runtimeFamily(r string) string {
+	return strings.TrimRight(r, "0123456789")
+}
+
+// checkEntryPoint statically checks that the entry point of f exists in the
+// source in src and has a signature that fits the trigger of the function.
+// Only Go, Python and Node.js are supported, other runtimes are skipped.
+func checkEntryPoint(src string, f Function) error {
+	ep := entryPoint(f)
+	switch runtimeFamily(f.Runtime) {
+	case "go":
+		return checkGoEntryPoint(src, ep, f)
+	case "python":
+		return checkPythonEntryPoint(src, ep, f)
+	case "nodejs":
+		return checkNodeEntryPoint(src, ep, f)
+	}
+	return nil
+}
+
+func isHTTPTrigger(f Function) bool {
+	return f.Trigger == "http"
+}
+
+func checkGoEntryPoint(src string, ep string, f Function) error {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, src, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return fmt.Errorf("can't parse go source: %s", err)
+	}
+
+	files := []*ast.File{}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			files = append(files, file)
+		}
+	}
+
+	// functions registered with the functions framework, usually in init()
+	// via functions.HTTP("Name", handler) or functions.CloudEvent(...)
+	registered := ""
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) < 2 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || (sel.Sel.Name != "HTTP" && sel.Sel.Name != "CloudEvent") {
+				return true
+			}
+			if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Value == strconv.Quote(ep) {
+				registered = sel.Sel.Name
+			}
+			return true
+		})
+	}
+	switch {
+	case registered == "HTTP" && !isHTTPTrigger(f):
+		return fmt.Errorf("entry point %s is registered as HTTP function but the trigger is %s", ep, f.Trigger)
+	case registered == "CloudEvent" && isHTTPTrigger(f):
+		return fmt.Errorf("entry point %s is registered as CloudEvent function but the trigger is http", ep)
+	case registered != "":
+		return nil
+	}
+
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv != nil || decl.Name.Name != ep {
+					continue
+				}
+				if !ast.IsExported(ep) {
+					return fmt.Errorf("entry point %s must be exported", ep)
+				}
+				return checkGoSignature(ep, decl.Type, f)
+
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if vs, ok := spec.(*ast.ValueSpec); ok {
+						for _, n := range vs.Names {
+							if n.Name == ep {
+								// can't check the signature of function variables
+								return nil
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("entry point %s not found in go source %s", ep, src)
+}
+
+func checkGoSignature(ep string, ft *ast.FuncType, f Function) error {
+	params := []ast.Expr{}
+	for _, p := range ft.Params.List {
+		n := len(p.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			params = append(params, p.Type)
+		}
+	}
+
+	if isHTTPTrigger(f) {
+		if len(params) != 2 || !isGoSelector(params[0], "ResponseWriter") || !isGoSelector(params[1], "*Request") {
+			return fmt.Errorf("entry point %s doesn't look like an http function, expected func(http.ResponseWriter, *http.Request)", ep)
+		}
+		return nil
+	}
+
+	if len(params) != 2 || !isGoSelector(params[0], "Context") || ft.Results == nil || len(ft.Results.List) != 1 {
+		return fmt.Errorf("entry point %s doesn't look like an event function, expected func(context.Context, event) error", ep)
+	}
+	return nil
+}
+
+// isGoSelector checks if e is a qualified type like http.ResponseWriter,
+// name can be prefixed with "*" for pointer types.
+func isGoSelector(e ast.Expr, name string) bool {
+	if strings.HasPrefix(name, "*") {
+		star, ok := e.(*ast.StarExpr)
+		if !ok {
+			return false
+		}
+		return isGoSelector(star.X, name[1:])
+	}
+	sel, ok := e.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == name
+}
+
+var (
+	pythonDefRegex        = regexp.MustCompile(`(?m)^(?:async\s+)?def\s+([A-Za-z_][A-Za-z0-9_]*)\s*\(`)
+	pythonDecoratorRegex  = regexp.MustCompile(`(?m)^@functions_framework\.(http|cloud_event)\s*\n(?:@.*\n)*(?:async\s+)?def\s+([A-Za-z_][A-Za-z0-9_]*)`)
+	pythonFromImportRegex = regexp.MustCompile(`(?m)^from\s+(\.*[A-Za-z_][A-Za-z0-9_.]*)\s+import\s+(?:\(([^)]*)\)|(.*))`)
+)
+
+// maxImportDepth limits how many re-exports are followed to find an entry
+// point.
+const maxImportDepth = 5
+
+func checkPythonEntryPoint(src string, ep string, f Function) error {
+	return checkPythonModule(src, filepath.Join(src, "main.py"), ep, f, 0)
+}
+
+func checkPythonModule(src string, file string, ep string, f Function, depth int) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// reported by the source checks
+			return nil
+		}
+		return err
+	}
+	code := strings.ReplaceAll(string(b), "\r\n", "\n")
+
+	for _, m := range pythonDecoratorRegex.FindAllStringSubmatch(code, -1) {
+		if m[2] != ep {
+			continue
+		}
+		if m[1] == "http" && !isHTTPTrigger(f) {
+			return fmt.Errorf("entry point %s is decorated as http function but the trigger is %s", ep, f.Trigger)
+		}
+		if m[1] == "cloud_event" && isHTTPTrigger(f) {
+			return fmt.Errorf("entry point %s is decorated as cloud event function but the trigger is http", ep)
+		}
+	}
+
+	for _, idx := range pythonDefRegex.FindAllStringSubmatchIndex(code, -1) {
+		if code[idx[2]:idx[3]] != ep {
+			continue
+		}
+		params, ok := splitParams(code[idx[1]:], ')')
+		if !ok {
+			return nil
+		}
+		return checkPythonParams(ep, params, pythonExpectedParams(f))
+	}
+
+	// follow re-exports like "from app import handler"
+	for _, m := range pythonFromImportRegex.FindAllStringSubmatch(code, -1) {
+		name, ok := pythonImportedName(m[2]+m[3], ep)
+		if !ok {
+			continue
+		}
+		module := pythonModuleFile(src, m[1])
+		if module == "" || depth >= maxImportDepth {
+			// imported from an installed package, can't be checked
+			return nil
+		}
+		return checkPythonModule(src, module, name, f, depth+1)
+	}
+
+	return fmt.Errorf("entry point %s not found in %s", ep, file)
+}
+
+// pythonImportedName returns the name in the imported module for ep if the
+// import list of a from-import contains it, "*" imports every name.
+func pythonImportedName(names string, ep string) (string, bool) {
+	if i := strings.Index(names, "#"); i >= 0 {
+		names = names[:i]
+	}
+	for _, n := range strings.Split(names, ",") {
+		fields := strings.Fields(n)
+		switch {
+		case len(fields) == 1 && (fields[0] == ep || fields[0] == "*"):
+			return ep, true
+		case len(fields) == 3 && fields[1] == "as" && fields[2] == ep:
+			return fields[0], true
+		}
+	}
+	return "", false
+}
+
+// pythonModuleFile returns the file of a module in src or an empty string if
+// it's not part of the source. Relative modules are resolved against src as
+// that's where main.py is.
+func pythonModuleFile(src string, module string) string {
+	p := filepath.Join(src, filepath.FromSlash(strings.ReplaceAll(strings.TrimLeft(module, "."), ".", "/")))
+	for _, c := range []string{p + ".py", filepath.Join(p, "__init__.py")} {
+		if fileExists(c) {
+			return c
+		}
+	}
+	return ""
+}
+
+// pythonExpectedParams returns the number of parameters a python function
+// is called with: (request) for http, (cloud_event) for gen2 events and
+// (event, context) for gen1 background functions.
+func pythonExpectedParams(f Function) int {
+	if isHTTPTrigger(f) || f.Gen2 {
+		return 1
+	}
+	return 2
+}
+
+var (
+	nodeExportRegexes = []string{
+		`(?:module\.)?exports\.%s\s*=`,
+		`(?:module\.)?exports\[\s*['"]%s['"]\s*\]\s*=`,
+		`export\s+(?:async\s+)?function\s*\*?\s*%s\s*\(`,
+		`export\s+(?:const|let|var)\s+%s\s*=`,
+		`export\s*\{[^}]*\b%s\b[^}]*\}`,
+		`module\.exports\s*=\s*\{[^}]*\b%s\b[^}]*\}`,
+	}
+	nodeReexportRegex   = regexp.MustCompile(`module\.exports\s*=\s*require\(\s*['"]([^'"]+)['"]\s*\)|export\s*\*\s*from\s*['"]([^'"]+)['"]`)
+	nodeRegisteredRegex = `functions\.(http|cloudEvent)\(\s*['"]%s['"]`
+	nodeParamsRegex     = `(?:(?:module\.)?exports\.%[1]s\s*=|export\s+(?:const|let|var)\s+%[1]s\s*=|function\s+%[1]s\s*)\s*(?:async\s*)?(?:function\s*[A-Za-z0-9_$]*\s*)?\(`
+)
+
+func checkNodeEntryPoint(src string, ep string, f Function) error {
+	files, err := nodeSourceFiles(src)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	quoted := regexp.QuoteMeta(ep)
+	seen := map[string]bool{}
+	for depth := 0; len(files) > 0 && depth <= maxImportDepth; depth++ {
+		// modules that are re-exported as a whole by the files
+		reexported := []string{}
+		for _, file := range files {
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			code := string(b)
+
+			if m := regexp.MustCompile(fmt.Sprintf(nodeRegisteredRegex, quoted)).FindStringSubmatch(code); m != nil {
+				if m[1] == "http" && !isHTTPTrigger(f) {
+					return fmt.Errorf("entry point %s is registered as http function but the trigger is %s", ep, f.Trigger)
+				}
+				if m[1] == "cloudEvent" && isHTTPTrigger(f) {
+					return fmt.Errorf("entry point %s is registered as cloud event function but the trigger is http", ep)
+				}
+				return nil
+			}
+
+			found := false
+			for _, r := range nodeExportRegexes {
+				if regexp.MustCompile(fmt.Sprintf(r, quoted)).MatchString(code) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				for _, m := range nodeReexportRegex.FindAllStringSubmatch(code, -1) {
+					if p := nodeModuleFile(filepath.Dir(file), m[1]+m[2]); p != "" {
+						reexported = append(reexported, p)
+					}
+				}
+				continue
+			}
+
+			if loc := regexp.MustCompile(fmt.Sprintf(nodeParamsRegex, quoted)).FindStringIndex(code); loc != nil {
+				if params, ok := splitParams(code[loc[1]:], ')'); ok {
+					return checkNodeParams(ep, params, nodeExpectedParams(f), f)
+				}
+			}
+			return nil
+		}
+		files = reexported
+	}
+
+	return fmt.Errorf("entry point %s is not exported by the node source in %s", ep, src)
+}
+
+// nodeExpectedParams returns the number of parameters a node function is
+// called with: (req, res) for http, (cloudEvent) for gen2 events and
+// (data, context) for gen1 background functions.
+func nodeExpectedParams(f Function) int {
+	if isHTTPTrigger(f) {
+		return 2
+	}
+	if f.Gen2 {
+		return 1
+	}
+	return 2
+}
+
+// nodeSourceFiles returns the files that can export the entry point: the
+// main file from package.json or index.js/function.js. If the main file is
+// built by the gcp-build script while deploying, e.g. from TypeScript, there
+// is nothing to check and no files are returned.
+func nodeSourceFiles(src string) ([]string, error) {
+	candidates := []string{"index.js", "function.js", "index.mjs", "index.cjs"}
+	pkg := packageJSON{}
+	if b, err := ioutil.ReadFile(filepath.Join(src, "package.json")); err == nil && json.Unmarshal(b, &pkg) == nil && pkg.Main != "" {
+		candidates = []string{pkg.Main}
+	}
+
+	res := []string{}
+	for _, c := range candidates {
+		p := filepath.Join(src, c)
+		if _, err := os.Stat(p); err == nil {
+			res = append(res, p)
+		}
+	}
+	if len(res) == 0 && !pkg.hasBuildStep() {
+		return nil, fmt.Errorf("no node source found in %s, expected one of: %s", src, strings.Join(candidates, ", "))
+	}
+	return res, nil
+}
+
+// nodeModuleFile resolves a relative import in dir to a file, an empty string
+// is returned for packages and files that don't exist.
+func nodeModuleFile(dir string, module string) string {
+	if !strings.HasPrefix(module, ".") {
+		return ""
+	}
+	p := filepath.Join(dir, filepath.FromSlash(module))
+	for _, c := range []string{p, p + ".js", p + ".mjs", p + ".cjs", filepath.Join(p, "index.js")} {
+		if fi, err := os.Stat(c); err == nil && fi.Mode().IsRegular() {
+			return c
+		}
+	}
+	return ""
+}
+
+// splitParams splits the parameter list at the start of s, up to the
+// closing character, into the individual parameters.
+func splitParams(s string, closing byte) ([]string, bool) {
+	depth := 0
+	params := []string{}
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth == 0 && s[i] == closing {
+				if p := strings.TrimSpace(s[start:i]); p != "" {
+					params = append(params, p)
+				}
+				return params, true
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				params = append(params, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return nil, false
+}
+
+// checkPythonParams checks that a python function with the given parameters
+// can be called with the expected number of positional arguments.
+func checkPythonParams(ep string, params []string, expected int) error {
+	required, total := 0, 0
+	for _, p := range params {
+		switch {
+		case p == "/" || p == "*":
+			continue
+		case strings.HasPrefix(p, "*"):
+			// *args or **kwargs accept any number of arguments
+			return nil
+		case !strings.Contains(p, "="):
+			required++
+		}
+		total++
+	}
+
+	if required > expected || total < expected {
+		return fmt.Errorf("entry point %s takes %d parameters but is called with %d", ep, total, expected)
+	}
+	return nil
+}
+
+// checkNodeParams checks that a node function doesn't expect more arguments
+// than it's called with, gen1 background functions also get a callback.
+func checkNodeParams(ep string, params []string, expected int, f Function) error {
+	max := expected
+	if !isHTTPTrigger(f) && !f.Gen2 {
+		max++
+	}
+	for _, p := range params {
+		if strings.HasPrefix(p, "...") {
+			return nil
+		}
+	}
+	if len(params) > max {
+		return fmt.Errorf("entry point %s takes %d parameters but is called with %d", ep, len(params), expected)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const goHTTPFunction = `package function
+
+import "net/http"
+
+func Func(w http.ResponseWriter, r *http.Request) {}
+`
+
+func TestCheckEntryPoint(t *testing.T) {
+	for _, tst := range []struct {
+		name          string
+		files         map[string]string
+		f             Function
+		expectedError string
+	}{
+		{
+			name:  "go http",
+			files: map[string]string{"function.go": goHTTPFunction, "function_test.go": "package function\n\nfunc Other() {}\n"},
+			f:     Function{Name: "Func", Runtime: "go121", Trigger: "http"},
+		},
+		{
+			name:  "go event with entrypoint",
+			files: map[string]string{"function.go": "package function\n\nimport \"context\"\n\nfunc HandleEvent(ctx context.Context, m PubSubMessage) error { return nil }\n"},
+			f:     Function{Name: "some-function", EntryPoint: "HandleEvent", Runtime: "go121", Trigger: "topic"},
+		},
+		{
+			name:  "go registered with functions framework",
+			files: map[string]string{"function.go": "package function\n\nimport \"github.com/GoogleCloudPlatform/functions-framework-go/functions\"\n\nfunc init() { functions.CloudEvent(\"Func\", handle) }\n"},
+			f:     Function{Name: "Func", Runtime: "go121", Trigger: "topic", Gen2: true},
+		},
+		{
+			name:          "go registered with the wrong type",
+			files:         map[string]string{"function.go": "package function\n\nimport \"github.com/GoogleCloudPlatform/functions-framework-go/functions\"\n\nfunc init() { functions.HTTP(\"Func\", handle) }\n"},
+			f:             Function{Name: "Func", Runtime: "go121", Trigger: "topic", Gen2: true},
+			expectedError: "registered as HTTP function but the trigger is topic",
+		},
+		{
+			name:          "go missing",
+			files:         map[string]string{"function.go": goHTTPFunction},
+			f:             Function{Name: "Other", Runtime: "go121", Trigger: "http"},
+			expectedError: "entry point Other not found in go source",
+		},
+		{
+			name:          "go only in tests",
+			files:         map[string]string{"function.go": goHTTPFunction, "function_test.go": "package function\n\nfunc Other() {}\n"},
+			f:             Function{Name: "Other", Runtime: "go121", Trigger: "http"},
+			expectedError: "entry point Other not found",
+		},
+		{
+			name:          "go wrong signature for http",
+			files:         map[string]string{"function.go": "package function\n\nimport \"context\"\n\nfunc Func(ctx context.Context, m Msg) error { return nil }\n"},
+			f:             Function{Name: "Func", Runtime: "go121", Trigger: "http"},
+			expectedError: "doesn't look like an http function",
+		},
+		{
+			name:          "go wrong signature for event",
+			files:         map[string]string{"function.go": goHTTPFunction},
+			f:             Function{Name: "Func", Runtime: "go121", Trigger: "bucket"},
+			expectedError: "doesn't look like an event function",
+		},
+		{
+			name:          "go unexported",
+			files:         map[string]string{"function.go": "package function\n\nimport \"net/http\"\n\nfunc handler(w http.ResponseWriter, r *http.Request) {}\n"},
+			f:             Function{Name: "Func", EntryPoint: "handler", Runtime: "go121", Trigger: "http"},
+			expectedError: "must be exported",
+		},
+		{
+			name:          "go syntax error",
+			files:         map[string]string{"function.go": "package function\n\nfunc Func( {\n"},
+			f:             Function{Name: "Func", Runtime: "go121", Trigger: "http"},
+			expectedError: "can't parse go source",
+		},
+		{
+			name:  "python http",
+			files: map[string]string{"main.py": "import flask\n\ndef helper(a, b, c):\n    pass\n\ndef hello_http(request):\n    return 'ok'\n"},
+			f:     Function{Name: "hello_http", Runtime: "python311", Trigger: "http"},
+		},
+		{
+			name:  "python gen1 background",
+			files: map[string]string{"main.py": "def hello_pubsub(event, context=None):\n    pass\n"},
+			f:     Function{Name: "hello_pubsub", Runtime: "python311", Trigger: "topic"},
+		},
+		{
+			name:  "python gen2 cloud event",
+			files: map[string]string{"main.py": "import functions_framework\n\n@functions_framework.cloud_event\ndef hello(\n    cloud_event,\n):\n    pass\n"},
+			f:     Function{Name: "hello", Runtime: "python311", Trigger: "topic", Gen2: true},
+		},
+		{
+			name:          "python decorator doesn't match trigger",
+			files:         map[string]string{"main.py": "import functions_framework\n\n@functions_framework.http\ndef hello(request):\n    pass\n"},
+			f:             Function{Name: "hello", Runtime: "python311", Trigger: "topic", Gen2: true},
+			expectedError: "decorated as http function but the trigger is topic",
+		},
+		{
+			name:          "python wrong number of parameters",
+			files:         map[string]string{"main.py": "def hello(request):\n    pass\n"},
+			f:             Function{Name: "hello", Runtime: "python311", Trigger: "topic"},
+			expectedError: "takes 1 parameters but is called with 2",
+		},
+		{
+			name:          "python nested def doesn't count",
+			files:         map[string]string{"main.py": "class A:\n    def hello(self, request):\n        pass\n"},
+			f:             Function{Name: "hello", Runtime: "python311", Trigger: "http"},
+			expectedError: "entry point hello not found",
+		},
+		{
+			name:  "python missing main.py is left to the source checks",
+			files: map[string]string{"other.py": "def hello(request):\n    pass\n"},
+			f:     Function{Name: "hello", Runtime: "python311", Trigger: "http"},
+		},
+		{
+			name:  "python re-exported from a module",
+			files: map[string]string{"main.py": "from app import helper, handler\n", "app.py": "def handler(request):\n    pass\n"},
+			f:     Function{Name: "handler", Runtime: "python311", Trigger: "http"},
+		},
+		{
+			name:  "python re-exported with an alias from a package",
+			files: map[string]string{"main.py": "from .lib.http import (\n    serve as hello,\n)\n", "lib/http/__init__.py": "def serve(request):\n    pass\n"},
+			f:     Function{Name: "hello", Runtime: "python311", Trigger: "http"},
+		},
+		{
+			name:  "python imported from an installed package",
+			files: map[string]string{"main.py": "from some_package import *\n"},
+			f:     Function{Name: "hello", Runtime: "python311", Trigger: "http"},
+		},
+		{
+			name:          "python re-exported with the wrong parameters",
+			files:         map[string]string{"main.py": "from app import hello\n", "app.py": "def hello(request):\n    pass\n"},
+			f:             Function{Name: "hello", Runtime: "python311", Trigger: "topic"},
+			expectedError: "takes 1 parameters but is called with 2",
+		},
+		{
+			name:          "python re-export doesn't contain the entry point",
+			files:         map[string]string{"main.py": "from app import *\n", "app.py": "def other(request):\n    pass\n"},
+			f:             Function{Name: "hello", Runtime: "python311", Trigger: "http"},
+			expectedError: "app.py",
+		},
+		{
+			name:  "node exports",
+			files: map[string]string{"index.js": "exports.helloHttp = (req, res) => {\n  res.send('ok');\n};\n"},
+			f:     Function{Name: "helloHttp", Runtime: "nodejs20", Trigger: "http"},
+		},
+		{
+			name:  "node main from package.json",
+			files: map[string]string{"package.json": `{"main": "src/app.js"}`, "src/app.js": "module.exports = { helloHttp, other };\n"},
+			f:     Function{Name: "helloHttp", Runtime: "nodejs20", Trigger: "http"},
+		},
+		{
+			name:  "node gen1 background with callback",
+			files: map[string]string{"index.js": "exports.helloPubSub = async function(data, context, callback) {};\n"},
+			f:     Function{Name: "helloPubSub", Runtime: "nodejs20", Trigger: "topic"},
+		},
+		{
+			name:  "node esm export",
+			files: map[string]string{"index.mjs": "export async function helloHttp(req, res) {}\n"},
+			f:     Function{Name: "helloHttp", Runtime: "nodejs20", Trigger: "http"},
+		},
+		{
+			name:  "node functions framework",
+			files: map[string]string{"index.js": "const functions = require('@google-cloud/functions-framework');\nfunctions.cloudEvent('helloEvent', (ce) => {});\n"},
+			f:     Function{Name: "helloEvent", Runtime: "nodejs20", Trigger: "topic", Gen2: true},
+		},
+		{
+			name:          "node functions framework wrong type",
+			files:         map[string]string{"index.js": "functions.http('helloEvent', (req, res) => {});\n"},
+			f:             Function{Name: "helloEvent", Runtime: "nodejs20", Trigger: "topic", Gen2: true},
+			expectedError: "registered as http function but the trigger is topic",
+		},
+		{
+			name:          "node too many parameters for cloud event",
+			files:         map[string]string{"index.js": "exports.helloEvent = (req, res) => {};\n"},
+			f:             Function{Name: "helloEvent", Runtime: "nodejs20", Trigger: "topic", Gen2: true},
+			expectedError: "takes 2 parameters but is called with 1",
+		},
+		{
+			name:  "node module re-exported",
+			files: map[string]string{"index.js": "module.exports = require('./lib');\n", "lib/index.js": "exports.helloHttp = (req, res) => {};\n"},
+			f:     Function{Name: "helloHttp", Runtime: "nodejs20", Trigger: "http"},
+		},
+		{
+			name:  "node main built by gcp-build",
+			files: map[string]string{"package.json": `{"main": "build/index.js", "scripts": {"gcp-build": "tsc"}}`, "src/index.ts": "export const helloHttp = (req: Request, res: Response) => {};\n"},
+			f:     Function{Name: "helloHttp", Runtime: "nodejs20", Trigger: "http"},
+		},
+		{
+			name:          "node not exported",
+			files:         map[string]string{"index.js": "function helloHttp(req, res) {}\n"},
+			f:             Function{Name: "helloHttp", Runtime: "nodejs20", Trigger: "http"},
+			expectedError: "is not exported",
+		},
+		{
+			name:          "node no source",
+			files:         map[string]string{"package.json": `{}`},
+			f:             Function{Name: "helloHttp", Runtime: "nodejs20", Trigger: "http"},
+			expectedError: "no node source found",
+		},
+		{
+			name:  "java is skipped",
+			files: map[string]string{"pom.xml": ""},
+			f:     Function{Name: "Func", EntryPoint: "com.example.Func", Runtime: "java17", Trigger: "http"},
+		},
+	} {
+		dir := t.TempDir()
+		writeFiles(t, dir, tst.files)
+
+		err := checkEntryPoint(dir, tst.f)
+		if tst.expectedError == "" {
+			if err != nil {
+				t.Errorf("%s: checkEntryPoint() failed, err: %s", tst.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tst.expectedError) {
+			t.Errorf("%s: checkEntryPoint() expected error %q, got: %v", tst.name, tst.expectedError, err)
+		}
+	}
+}
+
+func TestRuntimeFamily(t *testing.T) {
+	for r, expected := range map[string]string{"go121": "go", "nodejs20": "nodejs", "python311": "python", "java17": "java", "": ""} {
+		if f := runtimeFamily(r); f != expected {
+			t.Errorf("runtimeFamily(%s) got: %s   expected: %s", r, f, expected)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	envVarNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// names set by Cloud Functions itself
+	reservedEnvVarNames    = []string{"FUNCTION_TARGET", "FUNCTION_SIGNATURE_TYPE", "K_SERVICE", "K_REVISION", "K_CONFIGURATION", "PORT"}
+	reservedEnvVarPrefixes = []string{"X_GOOGLE_"}
+)
+
+// maxEnvVarsSize is the limit of the total size of the names and values of
+// the environment variables of a function.
+const maxEnvVarsSize = 32 * 1024
+
+// unsupportedYAMLError is returned for YAML that gcloud accepts in env vars
+// files but the plugin can't parse, e.g. block values. Such files are passed
+// to gcloud unchanged.
+type unsupportedYAMLError struct {
+	msg string
+}
+
+func (e unsupportedYAMLError) Error() string {
+	return e.msg
+}
+
+// loadEnvVarsFile reads the env_vars_file of f, a relative path is relative
+// to dir. The variables are merged with the other environment variables of
+// the function by envArgs. An unsupportedYAMLError is returned if the file
+// can't be parsed but might be valid for gcloud.
+func loadEnvVarsFile(dir string, f Function) (map[string]string, error) {
+	if f.EnvironmentVarsFile == "" {
+		return nil, nil
+	}
+	p := f.EnvironmentVarsFile
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+
+	vars, err := parseEnvVarsFile(p)
+	if _, ok := err.(unsupportedYAMLError); ok {
+		return nil, unsupportedYAMLError{fmt.Sprintf("env_vars_file %s: %s", f.EnvironmentVarsFile, err)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("env_vars_file %s: %s", f.EnvironmentVarsFile, err)
+	}
+	errs := ConfigErrors{}
+	for _, k := range sortedKeys(vars) {
+		errs.Append("env_vars_file "+f.EnvironmentVarsFile, validateEnvVarName(k))
+	}
+	return vars, errs.Err()
+}
+
+// validateEnvVarName checks that k can be used as name of an environment
+// variable of a function.
+func validateEnvVarName(k string) error {
+	if !envVarNameRegex.MatchString(k) {
+		return fmt.Errorf("invalid environment variable name %q, only letters, digits and _ are allowed and it can't start with a digit", k)
+	}
+	if containsString(reservedEnvVarNames, k) {
+		return fmt.Errorf("environment variable %s is reserved by Cloud Functions", k)
+	}
+	for _, prefix := range reservedEnvVarPrefixes {
+		if strings.HasPrefix(k, prefix) {
+			return fmt.Errorf("environment variable %s is reserved by Cloud Functions, names can't start with %s", k, prefix)
+		}
+	}
+	return nil
+}
+
+// parseEnvVarsFile reads a YAML file with environment variables as accepted
+// by gcloud's --env-vars-file: a flat mapping of names to scalar values.
+func parseEnvVarsFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseEnvVarsYAML(string(b))
+}
+
+// parseEnvVarsYAML parses the subset of YAML used by env vars files: one
+// "KEY: value" per line with optionally quoted values and comments.
+func parseEnvVarsYAML(s string) (map[string]string, error) {
+	res := map[string]string{}
+	for i, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		lineNo := i + 1
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, unsupportedYAMLError{fmt.Sprintf("line %d: nested values are not supported, expected KEY: value", lineNo)}
+		}
+		if strings.HasPrefix(trimmed, "- ") {
+			return nil, unsupportedYAMLError{fmt.Sprintf("line %d: lists are not supported, expected KEY: value", lineNo)}
+		}
+
+		idx := strings.Index(line, ":")
+		if idx == -1 {
+			return nil, fmt.Errorf("line %d: missing ':', expected KEY: value", lineNo)
+		}
+		key := unquoteYAMLKey(strings.TrimSpace(line[:idx]))
+		if key == "" {
+			return nil, fmt.Errorf("line %d: empty key", lineNo)
+		}
+		if _, ok := res[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %s", lineNo, key)
+		}
+
+		v, err := parseYAMLScalar(strings.TrimSpace(line[idx+1:]))
+		if _, ok := err.(unsupportedYAMLError); ok {
+			return nil, unsupportedYAMLError{fmt.Sprintf("line %d: %s", lineNo, err)}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNo, err)
+		}
+		res[key] = v
+	}
+	return res, nil
+}
+
+func unquoteYAMLKey(k string) string {
+	if len(k) >= 2 && (k[0] == '"' || k[0] == '\'') && k[len(k)-1] == k[0] {
+		return k[1 : len(k)-1]
+	}
+	return k
+}
+
+func parseYAMLScalar(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+
+	switch v[0] {
+	case '"':
+		end := -1
+		for i := 1; i < len(v); i++ {
+			if v[i] == '\\' {
+				if i+1 < len(v) && !strings.ContainsRune(`\"nt`, rune(v[i+1])) {
+					return "", unsupportedYAMLError{fmt.Sprintf("escape sequence \\%c is not supported", v[i+1])}
+				}
+				i++
+				continue
+			}
+			if v[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end == -1 {
+			return "", fmt.Errorf("unterminated double quoted value")
+		}
+		if err := checkYAMLTrailer(v[end+1:]); err != nil {
+			return "", err
+		}
+		r := strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n", `\t`, "\t")
+		return r.Replace(v[1:end]), nil
+
+	case '\'':
+		var b strings.Builder
+		for i := 1; i < len(v); i++ {
+			if v[i] != '\'' {
+				b.WriteByte(v[i])
+				continue
+			}
+			if i+1 < len(v) && v[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			if err := checkYAMLTrailer(v[i+1:]); err != nil {
+				return "", err
+			}
+			return b.String(), nil
+		}
+		return "", fmt.Errorf("unterminated single quoted value")
+
+	case '|', '>':
+		return "", unsupportedYAMLError{"block values are not supported"}
+
+	case '[', '{':
+		return "", unsupportedYAMLError{"lists and mappings are not supported as values"}
+
+	case '!':
+		return "", unsupportedYAMLError{"tags are not supported"}
+
+	case '&', '*':
+		return "", unsupportedYAMLError{"anchors and aliases are not supported"}
+	}
+
+	if idx := strings.Index(v, " #"); idx != -1 {
+		v = strings.TrimSpace(v[:idx])
+	}
+	switch v {
+	case "~", "null", "Null", "NULL":
+		return "", unsupportedYAMLError{"null values are not supported"}
+	}
+	return v, nil
+}
+
+func checkYAMLTrailer(s string) error {
+	s = strings.TrimSpace(s)
+	if s != "" && !strings.HasPrefix(s, "#") {
+		return fmt.Errorf("unexpected content after quoted value: %s", s)
+	}
+	return nil
+}
+
+// validateEnvSecretNames checks the names of the env secrets, they're
+// taken from the settings with the prefix, e.g. env_secret_ or
+// build_env_secret_.
+func validateEnvSecretNames(prefix string, envSecrets []string) ConfigErrors {
+	errs := ConfigErrors{}
+	for _, s := range envSecrets {
+		errs.Append(prefix+strings.ToLower(envSecretName(s)), validateEnvVarName(envSecretName(s)))
+	}
+	return errs
+}
+
+// validateEnvVars checks the names of the variables in the environment
+// setting of f and the total size of all its environment variables. The
+// names in the env_vars_file are checked by loadEnvVarsFile() and those of
+// the env secrets by validateEnvSecretNames().
+func validateEnvVars(cfg *Config, f Function) ConfigErrors {
+	errs := ConfigErrors{}
+	if len(f.Environment) > 0 {
+		for _, k := range sortedKeys(f.Environment[0]) {
+			errs.Append("environment", validateEnvVarName(k))
+		}
+	}
+
+	if f.passEnvVarsFile {
+		// gcloud doesn't allow --env-vars-file with the other env flags
+		if f.EnvMode == mergeMode {
+			errs.Add("env_vars_file %s is passed to gcloud unchanged, it can't be used with env_mode %s", f.EnvironmentVarsFile, mergeMode)
+		} else if len(functionEnvVars(cfg, f)) > 0 {
+			errs.Add("env_vars_file %s is passed to gcloud unchanged, it can't be combined with environment or env secrets", f.EnvironmentVarsFile)
+		}
+	}
+
+	size := 0
+	for k, v := range functionEnvVars(cfg, f) {
+		size += len(k) + len(v) + 1
+	}
+	if size > maxEnvVarsSize {
+		errs.Add("the environment variables are %s, the limit is %s", formatSize(int64(size)), formatSize(maxEnvVarsSize))
+	}
+	return errs
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvVarsYAML(t *testing.T) {
+	for _, tst := range []struct {
+		in             string
+		expected       map[string]string
+		expectedToBeOk bool
+	}{
+		{
+			in:             "# comment\nKEY: value\nOTHER: \"quoted: value # not a comment\"\n\nSINGLE: 'it''s'\nNUM: 123 # comment\nEMPTY:\n",
+			expected:       map[string]string{"KEY": "value", "OTHER": "quoted: value # not a comment", "SINGLE": "it's", "NUM": "123", "EMPTY": ""},
+			expectedToBeOk: true,
+		},
+		{
+			in:             "---\nESCAPED: \"a \\\"b\\\" c\"\r\n",
+			expected:       map[string]string{"ESCAPED": `a "b" c`},
+			expectedToBeOk: true,
+		},
+		{in: "KEY value\n"},
+		{in: "KEY:\n  nested: value\n"},
+		{in: "- KEY: value\n"},
+		{in: "KEY: \"unterminated\n"},
+		{in: "KEY: 'unterminated\n"},
+		{in: "KEY: |\n  block\n"},
+		{in: "KEY: [1, 2]\n"},
+		{in: "KEY: a\nKEY: b\n"},
+		{in: "KEY: \"a\" b\n"},
+	} {
+		res, err := parseEnvVarsYAML(tst.in)
+		if err != nil && tst.expectedToBeOk {
+			t.Errorf("parseEnvVarsYAML(%q) failed, err: %s", tst.in, err)
+			continue
+		}
+		if err == nil && !tst.expectedToBeOk {
+			t.Errorf("parseEnvVarsYAML(%q) should have failed, got: %#v", tst.in, res)
+			continue
+		}
+		if tst.expectedToBeOk && !reflect.DeepEqual(res, tst.expected) {
+			t.Errorf("parseEnvVarsYAML(%q) got: %#v   expected: %#v", tst.in, res, tst.expected)
+		}
+	}
+}
+
+func TestParseEnvVarsYAMLUnsupported(t *testing.T) {
+	// valid YAML the parser can't handle, such files are passed to gcloud
+	for _, in := range []string{
+		"KEY: |\n  block\n",
+		"KEY: {a: b}\n",
+		"KEY: \"caf\\u00e9\"\n",
+		"KEY: \"a\\x41\"\n",
+		"KEY: !!str 123\n",
+		"KEY: !custom value\n",
+		"KEY: &anchor value\n",
+		"KEY: null\n",
+		"KEY: NULL # comment\n",
+		"KEY: ~\n",
+	} {
+		_, err := parseEnvVarsYAML(in)
+		if _, ok := err.(unsupportedYAMLError); !ok {
+			t.Errorf("parseEnvVarsYAML(%q) expected unsupportedYAMLError, got: %v", in, err)
+		}
+	}
+}
+
+func TestParseEnvVarsFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), ".env.yaml")
+	if err := ioutil.WriteFile(p, []byte("KEY: value\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() err: %s", err)
+	}
+
+	if res, err := parseEnvVarsFile(p); err != nil || res["KEY"] != "value" {
+		t.Errorf("parseEnvVarsFile() got: %#v  err: %s", res, err)
+	}
+
+	if _, err := parseEnvVarsFile(p + ".missing"); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+func TestLoadEnvVarsFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".env.yaml":         "KEY: value\n",
+		"broken.env.yaml":   "KEY value\n",
+		"reserved.env.yaml": "PORT: 8080\nX_GOOGLE_FOO: bar\n",
+		"invalid.env.yaml":  "MY-KEY: value\n1KEY: value\n",
+		"config/.env.prod":  "KEY: prod\n",
+		"empty.env.yaml":    "# nothing here\n",
+		"block.env.yaml":    "KEY: |\n  line 1\n  line 2\n",
+	})
+
+	for _, tst := range []struct {
+		file           string
+		expected       map[string]string
+		expectedErrors []string
+	}{
+		{file: "", expected: nil},
+		{file: ".env.yaml", expected: map[string]string{"KEY": "value"}},
+		{file: "config/.env.prod", expected: map[string]string{"KEY": "prod"}},
+		{file: filepath.Join(dir, ".env.yaml"), expected: map[string]string{"KEY": "value"}},
+		{file: "empty.env.yaml", expected: map[string]string{}},
+		{file: "broken.env.yaml", expectedErrors: []string{"env_vars_file broken.env.yaml: line 1: missing ':'"}},
+		{file: "missing.yaml", expectedErrors: []string{"env_vars_file missing.yaml"}},
+		{file: "block.env.yaml", expectedErrors: []string{"env_vars_file block.env.yaml: line 1: block values are not supported"}},
+		{file: "reserved.env.yaml", expectedErrors: []string{
+			"env_vars_file reserved.env.yaml: environment variable PORT is reserved by Cloud Functions",
+			"env_vars_file reserved.env.yaml: environment variable X_GOOGLE_FOO is reserved by Cloud Functions, names can't start with X_GOOGLE_",
+		}},
+		{file: "invalid.env.yaml", expectedErrors: []string{`invalid environment variable name "1KEY"`, `invalid environment variable name "MY-KEY"`}},
+	} {
+		res, err := loadEnvVarsFile(dir, Function{EnvironmentVarsFile: tst.file})
+		if len(tst.expectedErrors) == 0 {
+			if err != nil || !reflect.DeepEqual(res, tst.expected) {
+				t.Errorf("loadEnvVarsFile(%s) got: %#v   err: %v   expected: %#v", tst.file, res, err, tst.expected)
+			}
+			continue
+		}
+		for _, e := range tst.expectedErrors {
+			if err == nil || !strings.Contains(err.Error(), e) {
+				t.Errorf("loadEnvVarsFile(%s) expected %q in error, got: %v", tst.file, e, err)
+			}
+		}
+	}
+}
+
+func TestParseConfigEnvVarsFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".env.yaml": "FROM_FILE: file\nDB_PASSWORD: from-file\nMODE: file\n",
+	})
+
+	os.Clearenv()
+	os.Setenv("DRONE_WORKSPACE", dir)
+	os.Setenv("PLUGIN_ACTION", "deploy")
+	os.Setenv("PLUGIN_TOKEN", validGCPKey)
+	os.Setenv("PLUGIN_ENV_SECRET_DB_PASSWORD", "pw")
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"F":[{"trigger":"http","runtime":"go121","env_vars_file":".env.yaml","environment":[{"MODE":"inline"}]}]}]`)
+
+	cfg, err := parseConfig()
+	if err != nil {
+		t.Fatalf("parseConfig() err: %s", err)
+	}
+	plan, err := CreateExecutionPlan(cfg)
+	if err != nil {
+		t.Fatalf("CreateExecutionPlan() err: %s", err)
+	}
+
+	args := strings.Join(plan.Steps[0], " ")
+	if !strings.Contains(args, "--set-env-vars ^:|:^DB_PASSWORD=pw:|:FROM_FILE=file:|:MODE=inline") || strings.Contains(args, "--env-vars-file") {
+		t.Errorf("expected the variables of the file to be merged, got: %s", args)
+	}
+
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"F":[{"trigger":"http","runtime":"go121","env_vars_file":"missing.yaml"}]}]`)
+	if _, err := parseConfig(); err == nil || !strings.Contains(err.Error(), "function F: env_vars_file missing.yaml") {
+		t.Errorf("expected an error for the missing file, got: %v", err)
+	}
+}
+
+func TestParseConfigUnsupportedEnvVarsFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"block.env.yaml": "CERT: |\n  line 1\n  line 2\n"})
+
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	os.Clearenv()
+	os.Setenv("DRONE_WORKSPACE", dir)
+	os.Setenv("PLUGIN_ACTION", "deploy")
+	os.Setenv("PLUGIN_TOKEN", validGCPKey)
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"F":[{"trigger":"http","runtime":"go121","env_vars_file":"block.env.yaml"}]}]`)
+
+	cfg, err := parseConfig()
+	if err != nil {
+		t.Fatalf("parseConfig() err: %s", err)
+	}
+	if !strings.Contains(buf.String(), "Warning: function F: env_vars_file block.env.yaml: line 1: block values are not supported, the file is passed to gcloud unchanged") {
+		t.Errorf("expected a warning, got: %s", buf.String())
+	}
+	plan, err := CreateExecutionPlan(cfg)
+	if err != nil {
+		t.Fatalf("CreateExecutionPlan() err: %s", err)
+	}
+	if args := strings.Join(plan.Steps[0], " "); !strings.Contains(args, "--env-vars-file block.env.yaml") || strings.Contains(args, "-env-vars ") {
+		t.Errorf("expected the file to be passed to gcloud, got: %s", args)
+	}
+
+	// gcloud can't combine the file with other variables
+	for fs, expected := range map[string]string{
+		`[{"F":[{"trigger":"http","runtime":"go121","env_vars_file":"block.env.yaml","environment":[{"K":"V"}]}]}]`: "it can't be combined with environment or env secrets",
+		`[{"F":[{"trigger":"http","runtime":"go121","env_vars_file":"block.env.yaml","env_mode":"merge"}]}]`:        "it can't be used with env_mode merge",
+	} {
+		os.Setenv("PLUGIN_FUNCTIONS", fs)
+		if _, err := parseConfig(); err == nil || !strings.Contains(err.Error(), "function F: env_vars_file block.env.yaml is passed to gcloud unchanged, "+expected) {
+			t.Errorf("parseConfig(%s) expected %q, got: %v", fs, expected, err)
+		}
+	}
+}
+
+func TestValidateEnvVarName(t *testing.T) {
+	for _, k := range []string{"KEY", "_KEY", "key_2", "FUNCTION_NAME", "GOOGLE_CLOUD_PROJECT"} {
+		if err := validateEnvVarName(k); err != nil {
+			t.Errorf("validateEnvVarName(%s) err: %s", k, err)
+		}
+	}
+	for _, k := range []string{"", "2KEY", "MY-KEY", "MY KEY", "KEY=1", "FUNCTION_TARGET", "FUNCTION_SIGNATURE_TYPE", "K_SERVICE", "K_REVISION", "K_CONFIGURATION", "PORT", "X_GOOGLE_FOO"} {
+		if err := validateEnvVarName(k); err == nil {
+			t.Errorf("validateEnvVarName(%s) should have failed", k)
+		}
+	}
+}
+
+func TestValidateEnvVars(t *testing.T) {
+	cfg := &Config{EnvSecrets: []string{"DB_PASSWORD=pw"}}
+
+	f := Function{Environment: []map[string]string{{"KEY": "value"}}, envFileVars: map[string]string{"FROM_FILE": "1"}}
+	if errs := validateEnvVars(cfg, f); len(errs) > 0 {
+		t.Errorf("validateEnvVars() err: %s", errs)
+	}
+
+	f = Function{Environment: []map[string]string{{"PORT": "8080", "my-key": "1", "K_SERVICE": "x"}}}
+	errs := validateEnvVars(cfg, f)
+	for _, e := range []string{
+		"environment: environment variable K_SERVICE is reserved by Cloud Functions",
+		"environment: environment variable PORT is reserved by Cloud Functions",
+		`environment: invalid environment variable name "my-key"`,
+	} {
+		if !strings.Contains(errs.Error(), e) {
+			t.Errorf("expected %q in errors, got: %s", e, errs)
+		}
+	}
+
+	// the env secrets and the file count towards the limit
+	big := strings.Repeat("x", maxEnvVarsSize/2)
+	cfg = &Config{EnvSecrets: []string{"BIG_SECRET=" + big}}
+	f = Function{envFileVars: map[string]string{"BIG_FILE": big}}
+	if errs := validateEnvVars(cfg, f); len(errs) == 0 || !strings.Contains(errs.Error(), "the environment variables are 32.0KB, the limit is 32.0KB") {
+		t.Errorf("expected a size error, got: %v", errs)
+	}
+	f.EnvSecrets = []string{}
+	if errs := validateEnvVars(cfg, f); len(errs) > 0 {
+		t.Errorf("validateEnvVars() err: %s", errs)
+	}
+}
+
+func TestParseConfigEnvVarNames(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("PLUGIN_ACTION", "deploy")
+	os.Setenv("PLUGIN_TOKEN", validGCPKey)
+	os.Setenv("PLUGIN_ENV_SECRET_K_SERVICE", "x")
+	os.Setenv("PLUGIN_BUILD_ENV_SECRET_1TOKEN", "token")
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"F":[{"trigger":"http","runtime":"go121","env_secrets":[],"environment":[{"X_GOOGLE_DEBUG":"1"}]}]}]`)
+
+	_, err := parseConfig()
+	for _, e := range []string{
+		"env_secret_k_service: environment variable K_SERVICE is reserved by Cloud Functions",
+		`build_env_secret_1token: invalid environment variable name "1TOKEN"`,
+		"function F: environment: environment variable X_GOOGLE_DEBUG is reserved by Cloud Functions",
+	} {
+		if err == nil || !strings.Contains(err.Error(), e) {
+			t.Errorf("expected %q, got: %v", e, err)
+		}
+	}
+}
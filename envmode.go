@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// replace all variables or secrets of the function, the default
+	replaceMode = "replace"
+	// only add or update the listed ones, the others are kept
+	mergeMode = "merge"
+)
+
+var validUpdateModes = []string{replaceMode, mergeMode}
+
+// functionEnvVars returns the environment variables of f. The variables of
+// the env_vars_file are overridden by env secrets, which are overridden by
+// the environment setting. Env secrets that are stored in Secret Manager are
+// passed as secrets instead, see syncEnvSecrets().
+func functionEnvVars(cfg *Config, f Function) map[string]string {
+	res := map[string]string{}
+	for k, v := range f.envFileVars {
+		res[k] = v
+	}
+	if cfg.EnvSecretsMode != envSecretsModeSecretManager {
+		for _, s := range functionEnvSecrets(cfg, f) {
+			if kv := strings.SplitN(s, "=", 2); len(kv) == 2 {
+				res[kv[0]] = kv[1]
+			}
+		}
+	}
+	if len(f.Environment) > 0 {
+		for k, v := range f.Environment[0] {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// envArgs returns the gcloud arguments that set the environment variables
+// of f. In merge mode the variables are updated with --update-env-vars and
+// variables set outside of the plugin are kept.
+func envArgs(cfg *Config, f Function) []string {
+	args := []string{}
+	if f.passEnvVarsFile {
+		return append(args, "--env-vars-file", f.EnvironmentVarsFile)
+	}
+
+	vars := functionEnvVars(cfg, f)
+	e := []string{}
+	for _, k := range sortedKeys(vars) {
+		e = append(e, fmt.Sprintf(`%s=%s`, k, vars[k]))
+	}
+
+	if f.EnvMode == mergeMode {
+		if len(e) > 0 {
+			args = append(args, "--update-env-vars", delimitedList(f, e))
+		}
+		if len(f.RemoveEnv) > 0 {
+			args = append(args, "--remove-env-vars", delimitedList(f, f.RemoveEnv))
+		}
+		return args
+	}
+
+	if len(e) > 0 {
+		args = append(args, "--set-env-vars", delimitedList(f, e))
+	}
+	return args
+}
+
+// secretsArgs returns the gcloud arguments that set the secrets of f, like
+// envArgs for the environment variables.
+func secretsArgs(f Function) []string {
+	args := []string{}
+
+	e := []string{}
+	for _, k := range sortedKeys(f.Secrets) {
+		e = append(e, fmt.Sprintf(`%s=%s`, k, f.Secrets[k]))
+	}
+
+	if f.SecretsMode == mergeMode {
+		if len(e) > 0 {
+			args = append(args, "--update-secrets", delimitedList(f, e))
+		}
+		if len(f.RemoveSecrets) > 0 {
+			args = append(args, "--remove-secrets", delimitedList(f, f.RemoveSecrets))
+		}
+		return args
+	}
+
+	if len(e) > 0 {
+		args = append(args, "--set-secrets", delimitedList(f, e))
+	}
+	return args
+}
+
+// delimitedList joins l with the environment delimiter of f, prefixed with
+// the ^delimiter^ escape of gcloud.
+func delimitedList(f Function, l []string) string {
+	return "^" + f.EnvironmentDelimiter + "^" + strings.Join(l, f.EnvironmentDelimiter)
+}
+
+// validateUpdateModes checks the env_mode and secrets_mode settings and the
+// variables and secrets they remove.
+func validateUpdateModes(cfg *Config, f Function) ConfigErrors {
+	errs := ConfigErrors{}
+
+	for _, m := range []string{f.EnvMode, f.SecretsMode} {
+		if m != "" && !containsString(validUpdateModes, m) {
+			errs.Add("invalid update mode [%s], must be one of: %s", m, strings.Join(validUpdateModes, ", "))
+		}
+	}
+
+	if f.EnvMode != mergeMode && len(f.RemoveEnv) > 0 {
+		errs.Add("remove_env requires env_mode: merge, replace already removes all variables that aren't set")
+	}
+	set := functionEnvVars(cfg, f)
+	for _, k := range f.RemoveEnv {
+		if k == "" {
+			errs.Add("remove_env contains an empty name")
+		} else if _, ok := set[k]; ok {
+			errs.Add("environment variable %s is set and removed", k)
+		}
+	}
+
+	if f.SecretsMode != mergeMode && len(f.RemoveSecrets) > 0 {
+		errs.Add("remove_secrets requires secrets_mode: merge, replace already removes all secrets that aren't set")
+	}
+	for _, k := range f.RemoveSecrets {
+		if k == "" {
+			errs.Add("remove_secrets contains an empty name")
+		} else if _, ok := f.Secrets[k]; ok {
+			errs.Add("secret %s is set and removed", k)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvArgs(t *testing.T) {
+	cfg := &Config{EnvSecrets: []string{"DB_PASSWORD=pw"}}
+	env := []map[string]string{{"B": "2", "A": "1"}}
+
+	tests := []struct {
+		f        Function
+		expected []string
+	}{
+		{
+			f:        Function{EnvironmentDelimiter: ":|:", Environment: env},
+			expected: []string{"--set-env-vars", "^:|:^A=1:|:B=2:|:DB_PASSWORD=pw"},
+		},
+		{
+			f:        Function{EnvironmentDelimiter: ":|:", EnvMode: "replace", Environment: env},
+			expected: []string{"--set-env-vars", "^:|:^A=1:|:B=2:|:DB_PASSWORD=pw"},
+		},
+		{
+			f:        Function{EnvironmentDelimiter: ":|:", EnvMode: "merge", Environment: env, RemoveEnv: []string{"OLD", "UNUSED"}},
+			expected: []string{"--update-env-vars", "^:|:^A=1:|:B=2:|:DB_PASSWORD=pw", "--remove-env-vars", "^:|:^OLD:|:UNUSED"},
+		},
+		{
+			f:        Function{EnvironmentDelimiter: ",", EnvMode: "merge", EnvSecrets: []string{}, envFileVars: map[string]string{"FROM_FILE": "1"}},
+			expected: []string{"--update-env-vars", "^,^FROM_FILE=1"},
+		},
+		{
+			f:        Function{EnvironmentDelimiter: ",", EnvMode: "merge", EnvSecrets: []string{}, RemoveEnv: []string{"OLD"}},
+			expected: []string{"--remove-env-vars", "^,^OLD"},
+		},
+		{
+			f:        Function{EnvironmentDelimiter: ",", EnvMode: "merge", EnvSecrets: []string{}},
+			expected: []string{},
+		},
+	}
+
+	for _, tst := range tests {
+		if res := envArgs(cfg, tst.f); !reflect.DeepEqual(res, tst.expected) {
+			t.Errorf("envArgs() got: %#v   expected: %#v", res, tst.expected)
+		}
+	}
+}
+
+func TestSecretsArgs(t *testing.T) {
+	secrets := map[string]string{"/etc/secrets/key": "key:latest", "API_KEY": "api-key:2"}
+
+	tests := []struct {
+		f        Function
+		expected []string
+	}{
+		{
+			f:        Function{EnvironmentDelimiter: ":|:", Secrets: secrets},
+			expected: []string{"--set-secrets", "^:|:^/etc/secrets/key=key:latest:|:API_KEY=api-key:2"},
+		},
+		{
+			f:        Function{EnvironmentDelimiter: ":|:", SecretsMode: "merge", Secrets: secrets, RemoveSecrets: []string{"OLD_KEY"}},
+			expected: []string{"--update-secrets", "^:|:^/etc/secrets/key=key:latest:|:API_KEY=api-key:2", "--remove-secrets", "^:|:^OLD_KEY"},
+		},
+		{
+			f:        Function{EnvironmentDelimiter: ":|:"},
+			expected: []string{},
+		},
+	}
+
+	for _, tst := range tests {
+		if res := secretsArgs(tst.f); !reflect.DeepEqual(res, tst.expected) {
+			t.Errorf("secretsArgs() got: %#v   expected: %#v", res, tst.expected)
+		}
+	}
+}
+
+func TestValidateUpdateModes(t *testing.T) {
+	cfg := &Config{EnvSecrets: []string{"DB_PASSWORD=pw"}}
+
+	ok := []Function{
+		{},
+		{EnvMode: "merge", RemoveEnv: []string{"OLD"}, Environment: []map[string]string{{"NEW": "1"}}},
+		{SecretsMode: "merge", RemoveSecrets: []string{"OLD"}, Secrets: map[string]string{"NEW": "new:1"}},
+		{EnvMode: "merge", EnvironmentVarsFile: ".env.yaml", envFileVars: map[string]string{"FROM_FILE": "1"}},
+	}
+	for _, f := range ok {
+		if errs := validateUpdateModes(cfg, f); len(errs) > 0 {
+			t.Errorf("validateUpdateModes(%#v) err: %s", f, errs)
+		}
+	}
+
+	tests := []struct {
+		f   Function
+		err string
+	}{
+		{f: Function{EnvMode: "update"}, err: "invalid update mode [update]"},
+		{f: Function{RemoveEnv: []string{"OLD"}}, err: "remove_env requires env_mode: merge"},
+		{f: Function{EnvMode: "merge", RemoveEnv: []string{"FROM_FILE"}, envFileVars: map[string]string{"FROM_FILE": "1"}}, err: "environment variable FROM_FILE is set and removed"},
+		{f: Function{EnvMode: "merge", RemoveEnv: []string{"DB_PASSWORD"}}, err: "environment variable DB_PASSWORD is set and removed"},
+		{f: Function{EnvMode: "merge", RemoveEnv: []string{""}}, err: "remove_env contains an empty name"},
+		{f: Function{RemoveSecrets: []string{"OLD"}}, err: "remove_secrets requires secrets_mode: merge"},
+		{f: Function{SecretsMode: "merge", Secrets: map[string]string{"KEY": "key:1"}, RemoveSecrets: []string{"KEY"}}, err: "secret KEY is set and removed"},
+	}
+	for _, tst := range tests {
+		if errs := validateUpdateModes(cfg, tst.f); len(errs) == 0 || !strings.Contains(errs.Error(), tst.err) {
+			t.Errorf("expected %q, got: %v", tst.err, errs)
+		}
+	}
+}
+
+func TestUpdateModeSchema(t *testing.T) {
+	_, err := parseFunctions(`[{"F":[{"trigger":"http","env_mode":"marge"}]}]`, "go121", nil)
+	if err == nil || !strings.Contains(err.Error(), `invalid value "marge" for setting "env_mode", did you mean "merge"?`) {
+		t.Errorf("expected a suggestion for env_mode, got: %v", err)
+	}
+}
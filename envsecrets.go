@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// envSecretName returns the name of the variable of an env secret as K=V.
+func envSecretName(s string) string {
+	return strings.SplitN(s, "=", 2)[0]
+}
+
+// functionEnvSecrets returns the env secrets a function receives: all of
+// them if the function doesn't set env_secrets, otherwise only the listed
+// ones. An empty env_secrets list means no env secrets at all.
+func functionEnvSecrets(cfg *Config, f Function) []string {
+	if f.EnvSecrets == nil {
+		res := make([]string, len(cfg.EnvSecrets))
+		copy(res, cfg.EnvSecrets)
+		return res
+	}
+
+	res := []string{}
+	for _, s := range cfg.EnvSecrets {
+		for _, name := range f.EnvSecrets {
+			if strings.EqualFold(envSecretName(s), name) {
+				res = append(res, s)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// validateEnvSecretScope checks that every env secret listed by a function
+// is provided as env_secret_ setting.
+func validateEnvSecretScope(envSecrets []string, f Function) error {
+	names := []string{}
+	for _, s := range envSecrets {
+		names = append(names, envSecretName(s))
+	}
+
+	errs := ConfigErrors{}
+	for _, name := range f.EnvSecrets {
+		found := false
+		for _, n := range names {
+			found = found || strings.EqualFold(n, name)
+		}
+		if found {
+			continue
+		}
+		msg := fmt.Sprintf("env secret %s is not set, add env_secret_%s to the settings", name, strings.ToLower(name))
+		if sg := suggest(strings.ToUpper(name), names); sg != "" {
+			msg += fmt.Sprintf(", did you mean %s?", sg)
+		}
+		errs.Add("%s", msg)
+	}
+	return errs.Err()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFunctionEnvSecrets(t *testing.T) {
+	functions, err := parseFunctions(`[{"All":[{"trigger":"http"}]},{"None":[{"trigger":"http","env_secrets":[]}]},{"Db":[{"trigger":"http","env_secrets":["DB_PASSWORD","api_key"]}]}]`, "go121", nil)
+	if err != nil {
+		t.Fatalf("parseFunctions() err: %s", err)
+	}
+
+	cfg := &Config{EnvSecrets: []string{"DB_PASSWORD=pw", "API_KEY=key", "OTHER=x"}}
+	expected := map[string][]string{
+		"All":  {"DB_PASSWORD=pw", "API_KEY=key", "OTHER=x"},
+		"None": {},
+		"Db":   {"DB_PASSWORD=pw", "API_KEY=key"},
+	}
+	for _, f := range functions {
+		if res := functionEnvSecrets(cfg, f); !reflect.DeepEqual(res, expected[f.Name]) {
+			t.Errorf("functionEnvSecrets(%s) got: %#v   expected: %#v", f.Name, res, expected[f.Name])
+		}
+	}
+}
+
+func TestValidateEnvSecretScope(t *testing.T) {
+	envSecrets := []string{"DB_PASSWORD=pw", "API_KEY=key"}
+	if err := validateEnvSecretScope(envSecrets, Function{EnvSecrets: []string{"db_password"}}); err != nil {
+		t.Errorf("validateEnvSecretScope() err: %s", err)
+	}
+
+	err := validateEnvSecretScope(envSecrets, Function{EnvSecrets: []string{"DB_PASWORD", "SMTP_PASSWORD"}})
+	for _, e := range []string{"env secret DB_PASWORD is not set, add env_secret_db_pasword to the settings, did you mean DB_PASSWORD?", "env secret SMTP_PASSWORD is not set"} {
+		if err == nil || !strings.Contains(err.Error(), e) {
+			t.Errorf("expected %q in error, got: %v", e, err)
+		}
+	}
+}
+
+func TestParseConfigScopedEnvSecrets(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("PLUGIN_ACTION", "deploy")
+	os.Setenv("PLUGIN_TOKEN", validGCPKey)
+	os.Setenv("PLUGIN_ENV_SECRET_DB_PASSWORD", "pw")
+	os.Setenv("PLUGIN_ENV_SECRET_API_KEY", "key")
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"Api":[{"trigger":"http","runtime":"go121","env_secrets":["API_KEY"]}]},{"Public":[{"trigger":"http","runtime":"go121","env_secrets":[]}]}]`)
+
+	cfg, err := parseConfig()
+	if err != nil {
+		t.Fatalf("parseConfig() err: %s", err)
+	}
+	plan, err := CreateExecutionPlan(cfg)
+	if err != nil {
+		t.Fatalf("CreateExecutionPlan() err: %s", err)
+	}
+
+	api, public := strings.Join(plan.Steps[0], " "), strings.Join(plan.Steps[1], " ")
+	if !strings.Contains(api, "--set-env-vars ^:|:^API_KEY=key") || strings.Contains(api, "DB_PASSWORD") {
+		t.Errorf("unexpected env vars for Api: %s", api)
+	}
+	if strings.Contains(public, "--set-env-vars") {
+		t.Errorf("expected no env vars for Public: %s", public)
+	}
+
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"Api":[{"trigger":"http","runtime":"go121","env_secrets":["API_TOKEN"]}]}]`)
+	if _, err := parseConfig(); err == nil || !strings.Contains(err.Error(), "function Api: env secret API_TOKEN is not set") {
+		t.Errorf("expected missing env secret error, got: %v", err)
+	}
+}
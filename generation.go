@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxGen1Timeout      = 540 * time.Second
+	maxGen2HTTPTimeout  = 3600 * time.Second
+	maxGen2EventTimeout = 540 * time.Second
+
+	minGen2MemoryMB = 128
+	maxGen2MemoryMB = 32 * 1024
+)
+
+// the memory sizes that can be used for gen1 functions, in MB
+var gen1MemoryMB = []int64{128, 256, 512, 1024, 2048, 4096, 8192}
+
+var memoryRegex = regexp.MustCompile(`^(\d+)\s*(MB|M|MI|MIB|GB|G|GI|GIB)?$`)
+
+// parseMemoryMB parses memory settings like 256MB, 2GB or 512Mi into MB,
+// numbers without unit are MB like in gcloud.
+func parseMemoryMB(s string) (int64, error) {
+	m := memoryRegex.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid memory %q, expected e.g. 256MB or 2GB", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if strings.HasPrefix(m[2], "G") {
+		n *= 1024
+	}
+	return n, nil
+}
+
+// parseTimeout parses timeouts like 60s or 9m, numbers without unit are
+// seconds like in gcloud.
+func parseTimeout(s string) (time.Duration, error) {
+	if _, err := strconv.Atoi(s); err == nil {
+		s += "s"
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q, expected e.g. 60s", s)
+	}
+	return d, nil
+}
+
+func generationName(gen2 bool) string {
+	if gen2 {
+		return "gen2"
+	}
+	return "gen1"
+}
+
+// isCloudEventType returns true for event types of Eventarc, like
+// google.cloud.storage.object.v1.finalized, which only work with gen2.
+func isCloudEventType(e string) bool {
+	return strings.HasPrefix(e, "google.cloud.") && strings.Contains(e, ".v1.")
+}
+
+// validateGeneration checks the settings of f that depend on whether it's
+// deployed as gen1 or gen2 function.
+func validateGeneration(f Function) ConfigErrors {
+	errs := ConfigErrors{}
+	gen := generationName(f.Gen2)
+
+	if ri, ok := runtimeInfo(f.Runtime); ok {
+		switch {
+		case f.Gen2 && !ri.Gen2:
+			errs.Add("runtime %s is not available for gen2 functions", f.Runtime)
+		case !f.Gen2 && !ri.Gen1:
+			errs.Add("runtime %s is only available for gen2 functions, set gen2: true", f.Runtime)
+		}
+	}
+
+	if f.Gen2 && f.HttpSecurityLevel != "" {
+		errs.Add("security_level is only supported by gen1 functions, gen2 functions always require https")
+	}
+
+	if f.Retry && f.Trigger == "http" {
+		errs.Add("retry is only supported by event driven functions")
+	}
+
+	if f.Trigger == "event" && !f.Gen2 && isCloudEventType(f.TriggerEvent) {
+		errs.Add("trigger_event %s is an Eventarc event type which requires gen2: true", f.TriggerEvent)
+	}
+
+	if f.Memory != "" {
+		mb, err := parseMemoryMB(f.Memory)
+		switch {
+		case err != nil:
+			errs.Add("%s", err)
+		case !f.Gen2 && !containsInt64(gen1MemoryMB, mb):
+			errs.Add("memory %s is not available for gen1 functions, use one of: 128MB, 256MB, 512MB, 1GB, 2GB, 4GB, 8GB", f.Memory)
+		case f.Gen2 && (mb < minGen2MemoryMB || mb > maxGen2MemoryMB):
+			errs.Add("memory %s is out of range for gen2 functions, must be between 128MB and 32GB", f.Memory)
+		}
+	}
+
+	if f.Timeout != "" {
+		d, err := parseTimeout(f.Timeout)
+		max := maxGen1Timeout
+		if f.Gen2 {
+			max = maxGen2EventTimeout
+			if f.Trigger == "http" {
+				max = maxGen2HTTPTimeout
+			}
+		}
+		switch {
+		case err != nil:
+			errs.Add("%s", err)
+		case d > max:
+			errs.Add("timeout %s is longer than the maximum of %s for %s %s functions", f.Timeout, max, gen, triggerKind(f))
+		}
+	}
+
+	return errs
+}
+
+// generationWarnings returns the problems of f that don't prevent the
+// deployment but are likely not intended.
+func generationWarnings(f Function) []string {
+	res := []string{}
+	if f.Gen2 && f.Trigger == "event" && strings.HasPrefix(f.TriggerEvent, "providers/") {
+		res = append(res, fmt.Sprintf("trigger_event %s is a legacy event type, gcloud maps it to an Eventarc trigger for gen2 functions", f.TriggerEvent))
+	}
+	return res
+}
+
+func triggerKind(f Function) string {
+	if f.Trigger == "http" {
+		return "http"
+	}
+	return "event driven"
+}
+
+func containsInt64(l []int64, n int64) bool {
+	for _, x := range l {
+		if x == n {
+			return true
+		}
+	}
+	return false
+}
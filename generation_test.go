@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMemoryMB(t *testing.T) {
+	for in, expected := range map[string]int64{
+		"256":   256,
+		"256MB": 256,
+		"512Mi": 512,
+		"2GB":   2048,
+		"1Gi":   1024,
+		"4gb":   4096,
+	} {
+		if n, err := parseMemoryMB(in); err != nil || n != expected {
+			t.Errorf("parseMemoryMB(%s) got: %d   expected: %d   err: %v", in, n, expected, err)
+		}
+	}
+	if _, err := parseMemoryMB("lots"); err == nil {
+		t.Errorf("expected error for invalid memory")
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	for in, expected := range map[string]time.Duration{
+		"60":  60 * time.Second,
+		"60s": 60 * time.Second,
+		"9m":  9 * time.Minute,
+		"1h":  time.Hour,
+	} {
+		if d, err := parseTimeout(in); err != nil || d != expected {
+			t.Errorf("parseTimeout(%s) got: %s   expected: %s   err: %v", in, d, expected, err)
+		}
+	}
+	for _, in := range []string{"forever", "-5s", "0"} {
+		if _, err := parseTimeout(in); err == nil {
+			t.Errorf("expected error for timeout %s", in)
+		}
+	}
+}
+
+func TestValidateGeneration(t *testing.T) {
+	for _, tst := range []struct {
+		f   Function
+		err string
+	}{
+		{f: Function{Runtime: "go121", Trigger: "http", Memory: "8GB", Timeout: "540s"}},
+		{f: Function{Runtime: "go121", Trigger: "http", Gen2: true, Memory: "16Gi", Timeout: "60m"}},
+		{f: Function{Runtime: "go111", Trigger: "http", Gen2: true}, err: "runtime go111 is not available for gen2 functions"},
+		{f: Function{Runtime: "go122", Trigger: "http"}, err: "runtime go122 is only available for gen2 functions, set gen2: true"},
+		{f: Function{Runtime: "go121", Trigger: "http", Gen2: true, HttpSecurityLevel: "secure-always"}, err: "security_level is only supported by gen1 functions"},
+		{f: Function{Runtime: "go121", Trigger: "http", Retry: true}, err: "retry is only supported by event driven functions"},
+		{f: Function{Runtime: "go121", Trigger: "event", TriggerEvent: "google.cloud.storage.object.v1.finalized"}, err: "is an Eventarc event type which requires gen2: true"},
+		{f: Function{Runtime: "go121", Trigger: "http", Memory: "3GB"}, err: "memory 3GB is not available for gen1 functions"},
+		{f: Function{Runtime: "go121", Trigger: "http", Gen2: true, Memory: "64GB"}, err: "memory 64GB is out of range for gen2 functions"},
+		{f: Function{Runtime: "go121", Trigger: "http", Timeout: "10m"}, err: "timeout 10m is longer than the maximum of 9m0s for gen1 http functions"},
+		{f: Function{Runtime: "go121", Trigger: "topic", Gen2: true, Timeout: "60m"}, err: "maximum of 9m0s for gen2 event driven functions"},
+	} {
+		errs := validateGeneration(tst.f)
+		if tst.err == "" {
+			if len(errs) > 0 {
+				t.Errorf("validateGeneration(%#v) err: %s", tst.f, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), tst.err) {
+			t.Errorf("validateGeneration(%#v) expected error %q, got: %v", tst.f, tst.err, errs)
+		}
+	}
+}
+
+func TestGenerationWarnings(t *testing.T) {
+	f := Function{Runtime: "go121", Trigger: "event", Gen2: true, TriggerEvent: "providers/cloud.firestore/eventTypes/document.write", TriggerResource: "projects/p/databases/(default)/documents/users/{id}"}
+	if w := generationWarnings(f); len(w) != 1 || !strings.Contains(w[0], "legacy event type") {
+		t.Errorf("expected legacy event warning, got: %v", w)
+	}
+	f.Gen2 = false
+	if w := generationWarnings(f); len(w) != 0 {
+		t.Errorf("expected no warnings for gen1, got: %v", w)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "fmt"
+
+// functionImpersonation returns the service account that is impersonated
+// for the gcloud commands of f, the setting of the function overrides the
+// one of the step.
+func functionImpersonation(cfg *Config, f Function) string {
+	if f.ImpersonateServiceAccount != "" {
+		return f.ImpersonateServiceAccount
+	}
+	return cfg.ImpersonateServiceAccount
+}
+
+// impersonationArgs returns the gcloud arguments to run a command as the
+// service account, none if it's empty.
+func impersonationArgs(account string) []string {
+	if account == "" {
+		return nil
+	}
+	return []string{"--impersonate-service-account", account}
+}
+
+// validateImpersonation checks the impersonate_service_account settings of
+// the step and the functions.
+func validateImpersonation(cfg *Config, functions Functions) ConfigErrors {
+	errs := ConfigErrors{}
+	if a := cfg.ImpersonateServiceAccount; a != "" && !isValidEmail(a) {
+		errs.Add("invalid impersonate_service_account %q, expected an email address", a)
+	}
+	for _, f := range functions {
+		if a := f.ImpersonateServiceAccount; a != "" && !isValidEmail(a) {
+			errs.Append("function "+f.Name, fmt.Errorf("invalid impersonate_service_account %q, expected an email address", a))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImpersonationArgs(t *testing.T) {
+	cfg := &Config{ImpersonateServiceAccount: "deployer@my-project.iam.gserviceaccount.com"}
+
+	if a := functionImpersonation(cfg, Function{}); a != "deployer@my-project.iam.gserviceaccount.com" {
+		t.Errorf("got: %s", a)
+	}
+	if a := functionImpersonation(cfg, Function{ImpersonateServiceAccount: "other@p.iam.gserviceaccount.com"}); a != "other@p.iam.gserviceaccount.com" {
+		t.Errorf("got: %s", a)
+	}
+	if args := impersonationArgs(""); args != nil {
+		t.Errorf("expected no args, got: %#v", args)
+	}
+	if args := impersonationArgs("a@b.com"); !reflect.DeepEqual(args, []string{"--impersonate-service-account", "a@b.com"}) {
+		t.Errorf("got: %#v", args)
+	}
+}
+
+func TestValidateImpersonation(t *testing.T) {
+	cfg := &Config{ImpersonateServiceAccount: "deployer@my-project.iam.gserviceaccount.com"}
+	if errs := validateImpersonation(cfg, Functions{{Name: "F", ImpersonateServiceAccount: "f@p.iam.gserviceaccount.com"}}); len(errs) > 0 {
+		t.Errorf("validateImpersonation() err: %s", errs)
+	}
+
+	cfg.ImpersonateServiceAccount = "deployer"
+	errs := validateImpersonation(cfg, Functions{{Name: "F", ImpersonateServiceAccount: "not an email"}})
+	for _, e := range []string{
+		`invalid impersonate_service_account "deployer", expected an email address`,
+		`function F: invalid impersonate_service_account "not an email"`,
+	} {
+		if !strings.Contains(errs.Error(), e) {
+			t.Errorf("expected %q in errors, got: %s", e, errs)
+		}
+	}
+}
+
+func TestImpersonationPlan(t *testing.T) {
+	cfg := &Config{
+		Project:                   "my-project",
+		Verbosity:                 "info",
+		ImpersonateServiceAccount: "deployer@my-project.iam.gserviceaccount.com",
+		Functions: Functions{
+			{Name: "A", Runtime: "go121", Trigger: "http"},
+			{Name: "B", Runtime: "go121", Trigger: "http", ImpersonateServiceAccount: "other@p.iam.gserviceaccount.com"},
+		},
+	}
+
+	for _, action := range []string{"deploy", "call", "delete"} {
+		cfg.Action = action
+		plan, err := CreateExecutionPlan(cfg)
+		if err != nil {
+			t.Fatalf("CreateExecutionPlan(%s) err: %s", action, err)
+		}
+		for i, expected := range []string{"deployer@my-project.iam.gserviceaccount.com", "other@p.iam.gserviceaccount.com"} {
+			step := plan.Steps[i]
+			if !reflect.DeepEqual(step[len(step)-2:], []string{"--impersonate-service-account", expected}) {
+				t.Errorf("%s of %s got: %#v", action, cfg.Functions[i].Name, step)
+			}
+		}
+	}
+
+	cfg.Action = "list"
+	plan, err := CreateExecutionPlan(cfg)
+	if err != nil {
+		t.Fatalf("CreateExecutionPlan(list) err: %s", err)
+	}
+	if args := strings.Join(plan.Steps[0], " "); !strings.HasSuffix(args, "--impersonate-service-account deployer@my-project.iam.gserviceaccount.com") {
+		t.Errorf("list got: %s", args)
+	}
+}
+
+func TestImpersonationDryRun(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	cfg := &Config{
+		Action:                    "deploy",
+		Project:                   "my-project",
+		Verbosity:                 "info",
+		ImpersonateServiceAccount: "deployer@my-project.iam.gserviceaccount.com",
+		Functions:                 Functions{{Name: "A", Runtime: "go121", Trigger: "http"}},
+	}
+	plan, err := CreateExecutionPlan(cfg)
+	if err != nil {
+		t.Fatalf("CreateExecutionPlan() err: %s", err)
+	}
+	e := NewEnv("/tmp", nil, &bytes.Buffer{}, &bytes.Buffer{}, true, false)
+	if err := ExecutePlan(e, plan); err != nil {
+		t.Fatalf("ExecutePlan() err: %s", err)
+	}
+	if token, err := accessToken(e, cfg.ImpersonateServiceAccount); err != nil || token != "" {
+		t.Errorf("accessToken() in dry run got: %q   err: %v", token, err)
+	}
+
+	for _, expected := range []string{
+		`Dry run, not running: gcloud []string{"--quiet", "functions", "deploy"`,
+		`"--impersonate-service-account", "deployer@my-project.iam.gserviceaccount.com"}`,
+		`gcloud []string{"auth", "print-access-token", "--impersonate-service-account", "deployer@my-project.iam.gserviceaccount.com"}`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in the output, got: %s", expected, buf.String())
+		}
+	}
+}
+
+func TestParseConfigImpersonation(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("PLUGIN_ACTION", "deploy")
+	os.Setenv("PLUGIN_TOKEN", validGCPKey)
+	os.Setenv("PLUGIN_IMPERSONATE_SERVICE_ACCOUNT", "deployer@my-project.iam.gserviceaccount.com")
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"F":[{"trigger":"http","runtime":"go121","impersonate_service_account":"f@my-project.iam.gserviceaccount.com"}]}]`)
+
+	cfg, err := parseConfig()
+	if err != nil {
+		t.Fatalf("parseConfig() err: %s", err)
+	}
+	if cfg.ImpersonateServiceAccount != "deployer@my-project.iam.gserviceaccount.com" || cfg.Functions[0].ImpersonateServiceAccount != "f@my-project.iam.gserviceaccount.com" {
+		t.Errorf("unexpected config: %#v", cfg)
+	}
+
+	os.Setenv("PLUGIN_ACTION", "delete")
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"F":[{"impersonate_service_account":"deployer"}]}]`)
+	if _, err := parseConfig(); err == nil || !strings.Contains(err.Error(), `function F: invalid impersonate_service_account "deployer"`) {
+		t.Errorf("expected an invalid email error, got: %v", err)
+	}
+}
+
+func TestAccessTokens(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	cfg := &Config{
+		ImpersonateServiceAccount: "deployer@my-project.iam.gserviceaccount.com",
+		Functions: Functions{
+			{Name: "A"},
+			{Name: "B", ImpersonateServiceAccount: "other@my-project.iam.gserviceaccount.com"},
+		},
+	}
+	tokens := accessTokens(cfg, NewEnv("/tmp", nil, &bytes.Buffer{}, &bytes.Buffer{}, true, false))
+	for _, f := range append(cfg.Functions, cfg.Functions...) {
+		if _, err := tokens(f); err != nil {
+			t.Fatalf("tokens(%s) err: %s", f.Name, err)
+		}
+	}
+
+	for _, account := range []string{"deployer@my-project.iam.gserviceaccount.com", "other@my-project.iam.gserviceaccount.com"} {
+		cmd := fmt.Sprintf(`gcloud []string{"auth", "print-access-token", "--impersonate-service-account", %q}`, account)
+		if n := strings.Count(buf.String(), cmd); n != 1 {
+			t.Errorf("expected the token of %s to be requested once, got %d times: %s", account, n, buf.String())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// prefixes of the environment variables that can be referenced from within
+// the functions setting, e.g. ${DRONE_COMMIT_SHA}
+var interpolationEnvPrefixes = []string{"DRONE_", "CI_"}
+
+// interpolationVars collects the variables that are available for expansion:
+// all DRONE_* and CI_* variables of the build plus the custom ones provided
+// via the "vars" setting.
+func interpolationVars() (map[string]string, error) {
+	res := map[string]string{}
+	for _, e := range os.Environ() {
+		s := strings.SplitN(e, "=", 2)
+		if len(s) != 2 {
+			continue
+		}
+		for _, p := range interpolationEnvPrefixes {
+			if strings.HasPrefix(s[0], p) {
+				res[s[0]] = s[1]
+			}
+		}
+	}
+
+	if v := os.Getenv("PLUGIN_VARS"); v != "" {
+		custom := map[string]string{}
+		if err := json.Unmarshal([]byte(v), &custom); err != nil {
+			return nil, fmt.Errorf("invalid vars setting: %s", err)
+		}
+		for k, v := range custom {
+			res[k] = v
+		}
+	}
+	return res, nil
+}
+
+// expandVars replaces every ${VAR} or ${VAR:-default} in s with the value
+// from vars. Referencing a variable that isn't defined (and has no default)
+// is an error. Use $${ to get a literal ${.
+func expandVars(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for {
+		idx := strings.Index(s, "${")
+		if idx == -1 {
+			b.WriteString(s)
+			break
+		}
+
+		if idx > 0 && s[idx-1] == '$' {
+			b.WriteString(s[:idx-1])
+			b.WriteString("${")
+			s = s[idx+2:]
+			continue
+		}
+
+		b.WriteString(s[:idx])
+		end := strings.Index(s[idx:], "}")
+		if end == -1 {
+			return "", fmt.Errorf("unterminated variable reference in %q", s)
+		}
+
+		expr := s[idx+2 : idx+end]
+		name, def, hasDefault := expr, "", false
+		if i := strings.Index(expr, ":-"); i != -1 {
+			name, def, hasDefault = expr[:i], expr[i+2:], true
+		}
+		if name == "" {
+			return "", fmt.Errorf("empty variable reference ${%s}", expr)
+		}
+
+		v, ok := vars[name]
+		switch {
+		case ok && v != "":
+			b.WriteString(v)
+		case hasDefault:
+			b.WriteString(def)
+		case ok:
+			// defined but empty and no default
+		default:
+			return "", fmt.Errorf("undefined variable ${%s}", name)
+		}
+		s = s[idx+end+1:]
+	}
+	return b.String(), nil
+}
+
+// expandTree expands variable references in all strings of v as decoded by
+// encoding/json, including nested lists and the keys and values of objects.
+// Keys that are equal after expanding them are an error.
+func expandTree(v interface{}, path string, vars map[string]string) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		s, err := expandVars(v, vars)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		return s, nil
+
+	case []interface{}:
+		for i := range v {
+			e, err := expandTree(v[i], fmt.Sprintf("%s[%d]", path, i), vars)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = e
+		}
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		res := make(map[string]interface{}, len(v))
+		origins := map[string]string{}
+		for _, k := range keys {
+			ek, err := expandVars(k, vars)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", joinFieldPath(path, k), err)
+			}
+			if o, ok := origins[ek]; ok {
+				err := fmt.Errorf("keys %q and %q are both %q after expanding variables", o, k, ek)
+				if path != "" {
+					err = fmt.Errorf("%s: %s", path, err)
+				}
+				return nil, err
+			}
+			origins[ek] = k
+
+			e, err := expandTree(v[k], joinFieldPath(path, ek), vars)
+			if err != nil {
+				return nil, err
+			}
+			res[ek] = e
+		}
+		return res, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{
+		"DRONE_COMMIT_SHA": "abc123",
+		"DRONE_BRANCH":     "feature-x",
+		"EMPTY":            "",
+	}
+
+	for _, tst := range []struct {
+		in             string
+		expected       string
+		expectedToBeOk bool
+	}{
+		{in: "no vars here", expected: "no vars here", expectedToBeOk: true},
+		{in: "${DRONE_COMMIT_SHA}", expected: "abc123", expectedToBeOk: true},
+		{in: "Func-${DRONE_BRANCH}-${DRONE_COMMIT_SHA}", expected: "Func-feature-x-abc123", expectedToBeOk: true},
+		{in: "${MISSING:-fallback}", expected: "fallback", expectedToBeOk: true},
+		{in: "${EMPTY:-fallback}", expected: "fallback", expectedToBeOk: true},
+		{in: "${EMPTY}", expected: "", expectedToBeOk: true},
+		{in: "${DRONE_BRANCH:-fallback}", expected: "feature-x", expectedToBeOk: true},
+		{in: "$${DRONE_BRANCH}", expected: "${DRONE_BRANCH}", expectedToBeOk: true},
+		{in: "price: $5", expected: "price: $5", expectedToBeOk: true},
+		{in: "${MISSING}", expectedToBeOk: false},
+		{in: "${}", expectedToBeOk: false},
+		{in: "${DRONE_BRANCH", expectedToBeOk: false},
+	} {
+		res, err := expandVars(tst.in, vars)
+		if err != nil && tst.expectedToBeOk {
+			t.Errorf("expandVars(%q) failed, err: %s", tst.in, err)
+			continue
+		}
+		if err == nil && !tst.expectedToBeOk {
+			t.Errorf("expandVars(%q) should have failed, got: %q", tst.in, res)
+			continue
+		}
+		if res != tst.expected {
+			t.Errorf("expandVars(%q) got: %q   expected: %q", tst.in, res, tst.expected)
+		}
+	}
+}
+
+func TestParseFunctionsInterpolation(t *testing.T) {
+	vars := map[string]string{"DRONE_COMMIT_SHA": "abc123", "DRONE_PULL_REQUEST": "42"}
+
+	functions, err := parseFunctions(`[{"Preview${DRONE_PULL_REQUEST}":[{"trigger":"topic","trigger_resource":"topic-${DRONE_PULL_REQUEST}","source":"./src/${TARGET:-default}","environment":[{"BUILD_HASH":"${DRONE_COMMIT_SHA}"}],"secrets":{"KEY":"secret-${DRONE_PULL_REQUEST}:1"}}]}]`, "go111", vars)
+	if err != nil {
+		t.Fatalf("parseFunctions() err: %s", err)
+	}
+	if len(functions) != 1 {
+		t.Fatalf("expected one function, got: %#v", functions)
+	}
+
+	f := functions[0]
+	if f.Name != "Preview42" {
+		t.Errorf("unexpected name: %s", f.Name)
+	}
+	if f.TriggerResource != "topic-42" {
+		t.Errorf("unexpected trigger resource: %s", f.TriggerResource)
+	}
+	if f.Source != "./src/default" {
+		t.Errorf("unexpected source: %s", f.Source)
+	}
+	if f.Environment[0]["BUILD_HASH"] != "abc123" {
+		t.Errorf("unexpected environment: %#v", f.Environment)
+	}
+	if f.Secrets["KEY"] != "secret-42:1" {
+		t.Errorf("unexpected secrets: %#v", f.Secrets)
+	}
+
+	if _, err := parseFunctions(`[{"Func":[{"trigger":"http","source":"${NOT_DEFINED}"}]}]`, "go111", vars); err == nil {
+		t.Errorf("expected error for undefined variable")
+	}
+
+	// keys are expanded too, but must stay unique
+	functions, err = parseFunctions(`[{"Func":[{"trigger":"http","environment":[{"PR_${DRONE_PULL_REQUEST}":"yes"}],"labels":{"pr-${DRONE_PULL_REQUEST}":"true"}}]}]`, "go111", vars)
+	if err != nil || functions[0].Environment[0]["PR_42"] != "yes" || functions[0].Labels["pr-42"] != "true" {
+		t.Errorf("unexpected result: %#v  err: %v", functions, err)
+	}
+	_, err = parseFunctions(`[{"Func":[{"trigger":"http","labels":{"pr-${DRONE_PULL_REQUEST}":"a","pr-42":"b"}}]}]`, "go111", vars)
+	if err == nil || !strings.Contains(err.Error(), `labels: keys "pr-${DRONE_PULL_REQUEST}" and "pr-42" are both "pr-42" after expanding variables`) {
+		t.Errorf("expected a duplicate key error, got: %v", err)
+	}
+	if _, err := parseFunctions(`[{"Func":[{"trigger":"http","labels":{"${NOT_DEFINED}":"a"}}]}]`, "go111", vars); err == nil || !strings.Contains(err.Error(), "undefined variable ${NOT_DEFINED}") {
+		t.Errorf("expected error for undefined variable, got: %v", err)
+	}
+
+	functions, err = parseFunctions("Func-${DRONE_PULL_REQUEST},Other", "go111", vars)
+	if err != nil || len(functions) != 2 || functions[0].Name != "Func-42" {
+		t.Errorf("unexpected result: %#v  err: %s", functions, err)
+	}
+}
+
+func TestInterpolationVars(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("DRONE_BRANCH", "main")
+	os.Setenv("CI_COMMIT_SHA", "abc")
+	os.Setenv("HOME", "/root")
+	os.Setenv("PLUGIN_VARS", `{"SUFFIX":"-staging"}`)
+
+	vars, err := interpolationVars()
+	if err != nil {
+		t.Fatalf("interpolationVars() err: %s", err)
+	}
+	if vars["DRONE_BRANCH"] != "main" || vars["CI_COMMIT_SHA"] != "abc" || vars["SUFFIX"] != "-staging" {
+		t.Errorf("missing vars, got: %#v", vars)
+	}
+	if _, ok := vars["HOME"]; ok {
+		t.Errorf("HOME should not be available for interpolation")
+	}
+
+	os.Setenv("PLUGIN_VARS", `not json`)
+	if _, err := interpolationVars(); err == nil {
+		t.Errorf("expected error for invalid vars setting")
+	}
+}
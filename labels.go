@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const maxLabels = 64
+
+var (
+	labelKeyRegex     = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValueRegex   = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+	invalidLabelChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+)
+
+// provenanceLabelVars maps the labels that trace a deployed function back to
+// its build to the drone variables they're taken from.
+var provenanceLabelVars = map[string]string{
+	"drone-repo":         "DRONE_REPO",
+	"drone-commit-sha":   "DRONE_COMMIT_SHA",
+	"drone-build-number": "DRONE_BUILD_NUMBER",
+	"drone-branch":       "DRONE_BRANCH",
+}
+
+// sanitizeLabelValue turns s into a valid label value: lower case, only
+// letters, digits, "_" and "-" and at most 63 characters.
+func sanitizeLabelValue(s string) string {
+	s = invalidLabelChars.ReplaceAllString(strings.ToLower(s), "-")
+	s = strings.Trim(s, "-")
+	if len(s) > 63 {
+		s = strings.TrimRight(s[:63], "-")
+	}
+	return s
+}
+
+// provenanceLabels returns the labels with the build that deploys the
+// functions, variables that aren't set are skipped.
+func provenanceLabels() map[string]string {
+	res := map[string]string{}
+	for label, env := range provenanceLabelVars {
+		if v := sanitizeLabelValue(os.Getenv(env)); v != "" {
+			res[label] = v
+		}
+	}
+	if v := sanitizeLabelValue(BuildTag); v != "" {
+		res["drone-gcf-version"] = v
+	}
+	return res
+}
+
+// validateLabels checks the labels of a function against the rules of GCP.
+func validateLabels(labels map[string]string) ConfigErrors {
+	errs := ConfigErrors{}
+	if len(labels) > maxLabels {
+		errs.Add("too many labels, %d including the ones added by the plugin but at most %d are allowed", len(labels), maxLabels)
+	}
+	for _, k := range sortedKeys(labels) {
+		switch {
+		case strings.HasPrefix(k, "goog"):
+			errs.Add("label %s is reserved, keys must not start with goog", k)
+		case !labelKeyRegex.MatchString(k):
+			errs.Add("invalid label key %q, keys must start with a lower case letter and only contain lower case letters, digits, _ and - (at most 63 characters)", k)
+		}
+		if !labelValueRegex.MatchString(labels[k]) {
+			errs.Add("invalid value %q of label %s, values can only contain lower case letters, digits, _ and - (at most 63 characters)", labels[k], k)
+		}
+	}
+	return errs
+}
+
+// validateFunctionLabels checks all labels f is deployed with. The digest
+// label of staged sources is only known when the source is uploaded, so a
+// placeholder is counted for it.
+func validateFunctionLabels(cfg *Config, f Function) ConfigErrors {
+	labels := functionLabels(cfg, f)
+	if cfg.StagingBucket != "" && !isRemoteSource(f.Source) {
+		if _, ok := labels[sourceDigestLabel]; !ok {
+			labels[sourceDigestLabel] = sourceDigestLabelValue(strings.Repeat("0", 64))
+		}
+	}
+	return validateLabels(labels)
+}
+
+// functionLabels returns all labels of a deployed function: the provenance
+// labels, the labels of the function which take precedence, and the digest
+// of the source archive.
+func functionLabels(cfg *Config, f Function) map[string]string {
+	res := map[string]string{}
+	for k, v := range cfg.ProvenanceLabels {
+		res[k] = v
+	}
+	for k, v := range f.Labels {
+		res[k] = v
+	}
+	if f.sourceDigest != "" {
+		res[sourceDigestLabel] = sourceDigestLabelValue(f.sourceDigest)
+	}
+	return res
+}
+
+// labelArgs returns the gcloud arguments to set the labels of f.
+func labelArgs(cfg *Config, f Function) []string {
+	args := []string{}
+	if f.ClearLabels {
+		args = append(args, "--clear-labels")
+	}
+	labels := functionLabels(cfg, f)
+	if len(labels) == 0 {
+		return args
+	}
+	l := []string{}
+	for _, k := range sortedKeys(labels) {
+		l = append(l, fmt.Sprintf("%s=%s", k, labels[k]))
+	}
+	return append(args, "--update-labels", strings.Join(l, ","))
+}
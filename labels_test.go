@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeLabelValue(t *testing.T) {
+	for in, expected := range map[string]string{
+		"oliver006/drone-gcf":   "oliver006-drone-gcf",
+		"feature/Add_Labels":    "feature-add_labels",
+		"[not-tagged]":          "not-tagged",
+		"v1.2.3":                "v1-2-3",
+		strings.Repeat("a", 70): strings.Repeat("a", 63),
+	} {
+		if res := sanitizeLabelValue(in); res != expected {
+			t.Errorf("sanitizeLabelValue(%s) got: %s   expected: %s", in, res, expected)
+		}
+	}
+}
+
+func TestProvenanceLabels(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("DRONE_REPO", "oliver006/drone-gcf")
+	os.Setenv("DRONE_COMMIT_SHA", "8F3A9C1D2E4B5A6978C0D1E2F3A4B5C6D7E8F9A0")
+	os.Setenv("DRONE_BUILD_NUMBER", "123")
+
+	savedTag := BuildTag
+	BuildTag = "v1.4.0"
+	defer func() { BuildTag = savedTag }()
+
+	expected := map[string]string{
+		"drone-repo":         "oliver006-drone-gcf",
+		"drone-commit-sha":   "8f3a9c1d2e4b5a6978c0d1e2f3a4b5c6d7e8f9a0",
+		"drone-build-number": "123",
+		"drone-gcf-version":  "v1-4-0",
+	}
+	if res := provenanceLabels(); !reflect.DeepEqual(res, expected) {
+		t.Errorf("provenanceLabels() got: %#v", res)
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	if errs := validateLabels(map[string]string{"team": "payments", "cost-center": "", "env_name": "prod-1"}); len(errs) > 0 {
+		t.Errorf("validateLabels() err: %s", errs)
+	}
+
+	errs := validateLabels(map[string]string{"Team": "x", "1st": "x", "google-thing": "x", "team": "Payments Team"})
+	for _, e := range []string{`invalid label key "1st"`, `invalid label key "Team"`, "label google-thing is reserved", `invalid value "Payments Team" of label team`} {
+		if !strings.Contains(errs.Error(), e) {
+			t.Errorf("expected %q in errors, got: %s", e, errs)
+		}
+	}
+}
+
+func TestValidateFunctionLabels(t *testing.T) {
+	labels := map[string]string{}
+	for i := 0; i < maxLabels-len(provenanceLabelVars); i++ {
+		labels[fmt.Sprintf("label-%d", i)] = "x"
+	}
+	f := Function{Name: "F", Labels: labels}
+	cfg := &Config{ProvenanceLabels: map[string]string{}}
+	for k := range provenanceLabelVars {
+		cfg.ProvenanceLabels[k] = "x"
+	}
+	if errs := validateFunctionLabels(cfg, f); len(errs) > 0 {
+		t.Errorf("validateFunctionLabels() err: %s", errs)
+	}
+
+	// the digest label of the staged source is one too many
+	cfg.StagingBucket = "bucket"
+	errs := validateFunctionLabels(cfg, f)
+	if len(errs) != 1 || errs[0].Error() != "too many labels, 65 including the ones added by the plugin but at most 64 are allowed" {
+		t.Errorf("validateFunctionLabels() with staging got: %s", errs)
+	}
+	f.Source = "gs://bucket/source.zip"
+	if errs := validateFunctionLabels(cfg, f); len(errs) > 0 {
+		t.Errorf("validateFunctionLabels() with remote source err: %s", errs)
+	}
+}
+
+func TestLabelArgs(t *testing.T) {
+	cfg := &Config{ProvenanceLabels: map[string]string{"drone-repo": "org-repo", "drone-build-number": "7"}}
+
+	f := Function{Labels: map[string]string{"team": "payments", "drone-build-number": "override"}, ClearLabels: true, sourceDigest: strings.Repeat("ab", 32)}
+	expected := []string{"--clear-labels", "--update-labels", "drone-build-number=override,drone-gcf-source-sha256=" + strings.Repeat("ab", 16) + ",drone-repo=org-repo,team=payments"}
+	if args := labelArgs(cfg, f); !reflect.DeepEqual(args, expected) {
+		t.Errorf("labelArgs() got: %#v   expected: %#v", args, expected)
+	}
+
+	if args := labelArgs(&Config{}, Function{}); len(args) != 0 {
+		t.Errorf("expected no label args, got: %#v", args)
+	}
+}
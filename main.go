@@ -5,11 +5,11 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -20,6 +20,12 @@ type Function struct {
 	TriggerResource   string `json:"trigger_resource"`
 	HttpSecurityLevel string `json:"security_level"`
 
+	// used for trigger==eventarc
+	EventFilters            map[string]string `json:"event_filters"`
+	EventFiltersPathPattern map[string]string `json:"event_filters_path_pattern"`
+	TriggerLocation         string            `json:"trigger_location"`
+	TriggerServiceAccount   string            `json:"trigger_service_account"`
+
 	AllowUnauthenticated bool   `json:"allow_unauthenticated"`
 	Gen2                 bool   `json:"gen2"`
 	EntryPoint           string `json:"entrypoint"`
@@ -35,6 +41,10 @@ type Function struct {
 	EnvironmentDelimiter string              `json:"environment_delimiter"`
 	Environment          []map[string]string `json:"environment"`
 	Secrets              map[string]string   `json:"secrets"`
+	EnvMode              string              `json:"env_mode"`
+	RemoveEnv            []string            `json:"remove_env"`
+	SecretsMode          string              `json:"secrets_mode"`
+	RemoveSecrets        []string            `json:"remove_secrets"`
 
 	EnvironmentVarsFile string `json:"env_vars_file"`
 	// used for action==call
@@ -42,6 +52,32 @@ type Function struct {
 
 	IngressSettings string `json:"ingress_settings"`
 	EgressSettings  string `json:"egress_settings"`
+
+	MinInstances      *int     `json:"min_instances"`
+	MaxInstances      *int     `json:"max_instances"`
+	ClearMinInstances bool     `json:"clear_min_instances"`
+	ClearMaxInstances bool     `json:"clear_max_instances"`
+	Concurrency       *int     `json:"concurrency"`
+	CPU               *float64 `json:"cpu"`
+
+	Labels      map[string]string `json:"labels"`
+	ClearLabels bool              `json:"clear_labels"`
+
+	Build *BuildConfig `json:"build"`
+
+	// names of the env secrets the function receives, all if not set
+	EnvSecrets []string `json:"env_secrets"`
+
+	// service account the gcloud commands of the function run as, overrides
+	// the impersonate_service_account setting of the step
+	ImpersonateServiceAccount string `json:"impersonate_service_account"`
+
+	// sha256 of the staged source archive, set when deploying
+	sourceDigest string
+	// variables read from the env_vars_file
+	envFileVars map[string]string
+	// the env_vars_file can't be parsed and is passed to gcloud unchanged
+	passEnvVarsFile bool
 }
 
 type Functions []Function
@@ -56,7 +92,42 @@ type Config struct {
 	Runtime    string
 	Verbosity  string
 	EnvSecrets []string
-	Functions  Functions
+	// build environment variables from PLUGIN_BUILD_ENV_SECRET_*, as K=V
+	BuildEnvSecrets []string
+	Functions       Functions
+
+	// how to authenticate, see resolveAuthMode()
+	AuthMode string
+	// OIDC token of an external account, e.g. from the CI
+	OIDCToken string
+	// service account the gcloud commands run as, see functionImpersonation()
+	ImpersonateServiceAccount string
+
+	MaxSourceSize     int64
+	FailOnSecretFiles bool
+	RefreshRuntimes   bool
+	VerifySecrets     bool
+
+	// env secrets are passed as variables or stored in Secret Manager, see
+	// syncEnvSecrets()
+	EnvSecretsMode   string
+	EnvSecretsPrefix string
+
+	// target of a drone promotion, from DRONE_DEPLOY_TO
+	DeployTarget string
+
+	// added to every deployed function, see provenanceLabels()
+	ProvenanceLabels map[string]string
+
+	// sources are uploaded to gs://StagingBucket/StagingPrefix<sha256>.zip
+	StagingBucket string
+	StagingPrefix string
+
+	// archives of the source directories, see sourceArchive()
+	archives map[string]*SourceArchive
+
+	// gs:// urls of the archives that were uploaded or found in the bucket
+	staged map[string]bool
 }
 
 const (
@@ -72,129 +143,81 @@ var (
 	BuildTag  string
 )
 
+var (
+	// the values accepted by the enum-like function settings, these are shared
+	// between the validation and the published json schema
+	validRuntimes        = catalogRuntimeNames(runtimeCatalog)
+	validTriggerTypes    = []string{"http", "bucket", "topic", "event", "eventarc"}
+	validSecureTypes     = []string{"secure-optional", "secure-always"}
+	validIngressSettings = []string{"all", "internal-only", "internal-and-gclb"}
+	validEgressSettings  = []string{"all", "private-ranges-only"}
+)
+
 func isValidRuntime(r string) bool {
-	return map[string]bool{
-		"nodejs10":  true,
-		"nodejs12":  true,
-		"nodejs14":  true,
-		"nodejs16":  true,
-		"nodejs18":  true,
-		"nodejs20":  true,
-		"python37":  true,
-		"python38":  true,
-		"python39":  true,
-		"python310": true,
-		"python311": true,
-		"go111":     true,
-		"go113":     true,
-		"go116":     true,
-		"go118":     true,
-		"go119":     true,
-		"go120":     true,
-		"go121":     true,
-		"java11":    true,
-		"java17":    true,
-		"dotnet3":   true,
-		"dotnet6":   true,
-		"ruby26":    true,
-		"ruby27":    true,
-		"ruby30":    true,
-		"php81":     true,
-		"php74":     true,
-	}[r]
+	return containsString(validRuntimes, r)
 }
 
 func isValidTriggerType(t string) bool {
-	return map[string]bool{
-		"http":   true,
-		"bucket": true,
-		"topic":  true,
-		"event":  true,
-	}[t]
+	return containsString(validTriggerTypes, t)
 }
 
 func isValidSecureType(s string) bool {
-	return map[string]bool{
-		"secure-optional": true,
-		"secure-always":   true,
-	}[s]
+	return containsString(validSecureTypes, s)
 }
 
 func isValidIngressSettings(s string) bool {
-	return map[string]bool{
-		"all":               true,
-		"internal-only":     true,
-		"internal-and-gclb": true,
-	}[s]
+	return containsString(validIngressSettings, s)
 }
 
 func isValidEgressSettings(s string) bool {
-	return map[string]bool{
-		"all":                 true,
-		"private-ranges-only": true,
-	}[s]
+	return containsString(validEgressSettings, s)
 }
 
-func isValidFunctionForDeploy(f Function) bool {
-	if !isValidRuntime(f.Runtime) {
-		log.Printf("Missing or invalid runtime [%s] for function: %s", f.Runtime, f.Name)
-		return false
+// parseFunctions parses the functions setting which is either a comma
+// separated list of function names, a json list of function names or a json
+// list of functions with their settings. Functions without a runtime get the
+// runtime of the step or, if that's not set either, defaultRuntime(). All
+// problems found are returned together.
+func parseFunctions(e string, stepRuntime string, vars map[string]string) ([]Function, error) {
+	if t := strings.TrimSpace(e); !strings.HasPrefix(t, "[") && !strings.HasPrefix(t, "{") {
+		return functionsByName(strings.Split(e, ","), vars)
 	}
-
-	if f.IngressSettings != "" && !isValidIngressSettings(f.IngressSettings) {
-		log.Printf("Invalid ingress settings for function %s", f.Name)
-		return false
-	}
-
-	if f.EgressSettings != "" && !isValidEgressSettings(f.EgressSettings) {
-		log.Printf("Invalid egress settings settings for function %s", f.Name)
-		return false
-	}
-
-	if f.Trigger == "http" && f.HttpSecurityLevel != "" && !isValidSecureType(f.HttpSecurityLevel) {
-		log.Printf("Invalid Secure Level for http trigger for function %s", f.Name)
-		return false
+	if names := []string{}; json.Unmarshal([]byte(e), &names) == nil {
+		return functionsByName(names, vars)
 	}
 
-	if (f.Trigger == "" && f.TriggerEvent == "" && f.TriggerResource == "") || !isValidTriggerType(f.Trigger) {
-		log.Printf("Missing or invalid trigger for function %s", f.Name)
-		return false
-	}
-
-	if f.Trigger != "http" && f.TriggerResource == "" {
-		log.Printf("Missing or invalid trigger resource for function %s", f.Name)
-		return false
-	}
-
-	if f.Trigger == "event" && f.TriggerEvent == "" {
-		log.Printf("Missing trigger event for function %s", f.Name)
-		return false
-	}
-
-	return true
-}
-
-func parseFunctions(e string, defaultRuntime string) []Function {
 	res := Functions{}
-	d := []map[string]Functions{}
+	d := []map[string][]json.RawMessage{}
 	if err := json.Unmarshal([]byte(e), &d); err != nil {
-		if s := strings.Split(e, ","); e != "" && len(s) > 0 && s[0] != "" {
-			for _, n := range s {
-				n = strings.TrimSpace(n)
-				if n != "" {
-					res = append(res, Function{Name: n})
-				}
-			}
-		}
-		return res
+		return nil, fmt.Errorf("invalid functions setting, expected a list of functions with their settings: %s", err)
 	}
 
+	errs := ConfigErrors{}
 	for _, v := range d {
-		for k, fs := range v {
-			for _, f := range fs {
-				f.Name = strings.TrimSpace(k)
+		names := make([]string, 0, len(v))
+		for k := range v {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		for _, k := range names {
+			for _, raw := range v[k] {
+				name, err := expandVars(strings.TrimSpace(k), vars)
+				if err != nil {
+					errs.Append("function "+k, err)
+					continue
+				}
+				f, err := decodeFunction(raw, vars)
+				if err != nil {
+					errs.Append("function "+name, err)
+					continue
+				}
+				f.Name = name
 				if f.Runtime == "" {
-					f.Runtime = defaultRuntime
+					f.Runtime = stepRuntime
+				}
+				if f.Runtime == "" {
+					f.Runtime = defaultRuntime(f.Gen2)
 				}
 				if f.EnvironmentDelimiter == "" {
 					f.EnvironmentDelimiter = defaultEnvVarDelimiter
@@ -203,17 +226,35 @@ func parseFunctions(e string, defaultRuntime string) []Function {
 			}
 		}
 	}
-	return res
+	return res, errs.Err()
+}
+
+// functionsByName returns the functions with the given names and no other
+// settings, e.g. for the delete action.
+func functionsByName(names []string, vars map[string]string) ([]Function, error) {
+	res := Functions{}
+	for _, n := range names {
+		n, err := expandVars(strings.TrimSpace(n), vars)
+		if err != nil {
+			return nil, fmt.Errorf("function name: %s", err)
+		}
+		if n != "" {
+			res = append(res, Function{Name: n})
+		}
+	}
+	return res, nil
 }
 
 func getProjectFromToken(token string) string {
-	data := struct {
-		ProjectID string `json:"project_id"`
-	}{}
+	data := credentials{}
 	err := json.Unmarshal([]byte(token), &data)
 	if err != nil {
 		return ""
 	}
+	if data.ProjectID == "" {
+		// external accounts and users don't belong to a project
+		return data.QuotaProjectID
+	}
 	return data.ProjectID
 }
 
@@ -235,45 +276,139 @@ func parseConfig() (*Config, error) {
 		cfg.Verbosity = "warning"
 	}
 
+	cfg.FailOnSecretFiles = os.Getenv("PLUGIN_FAIL_ON_SECRET_FILES") == "true"
+	cfg.RefreshRuntimes = os.Getenv("PLUGIN_REFRESH_RUNTIMES") == "true"
+	cfg.VerifySecrets = os.Getenv("PLUGIN_VERIFY_SECRETS") == "true"
+	cfg.EnvSecretsMode = envSecretsModeEnv
+	if s := os.Getenv("PLUGIN_ENV_SECRETS_MODE"); s != "" {
+		cfg.EnvSecretsMode = s
+	}
+	cfg.EnvSecretsPrefix = defaultEnvSecretsPrefix
+	if s, ok := os.LookupEnv("PLUGIN_ENV_SECRETS_PREFIX"); ok {
+		cfg.EnvSecretsPrefix = s
+	}
+	cfg.DeployTarget = os.Getenv("DRONE_DEPLOY_TO")
+	if os.Getenv("PLUGIN_PROVENANCE_LABELS") != "false" {
+		cfg.ProvenanceLabels = provenanceLabels()
+	}
+	if s := os.Getenv("PLUGIN_MAX_SOURCE_SIZE"); s != "" {
+		n, err := parseSize(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_source_size: %s", err)
+		}
+		cfg.MaxSourceSize = n
+	}
+	if s := os.Getenv("PLUGIN_STAGING_BUCKET"); s != "" {
+		bucket, prefix, err := parseStagingBucket(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid staging_bucket: %s", err)
+		}
+		cfg.StagingBucket, cfg.StagingPrefix = bucket, prefix
+	}
+
 	PluginEnvSecretPrefix := "PLUGIN_ENV_SECRET_"
+	PluginBuildEnvSecretPrefix := "PLUGIN_BUILD_ENV_SECRET_"
 	for _, e := range os.Environ() {
 		if s := strings.SplitN(e, "=", 2); len(s) > 0 && strings.HasPrefix(s[0], PluginEnvSecretPrefix) {
 			k := strings.TrimPrefix(s[0], PluginEnvSecretPrefix)
 			v := os.Getenv(s[0])
 			cfg.EnvSecrets = append(cfg.EnvSecrets, fmt.Sprintf(`%s=%s`, k, v))
 		}
+		if s := strings.SplitN(e, "=", 2); len(s) > 0 && strings.HasPrefix(s[0], PluginBuildEnvSecretPrefix) {
+			k := strings.TrimPrefix(s[0], PluginBuildEnvSecretPrefix)
+			v := os.Getenv(s[0])
+			cfg.BuildEnvSecrets = append(cfg.BuildEnvSecrets, fmt.Sprintf(`%s=%s`, k, v))
+		}
 	}
 
+	// validating the config must work without credentials, e.g. for PRs from forks
+	needsCredentials := cfg.Action != "validate"
+
 	if cfg.Token == "" {
 		cfg.Token = os.Getenv("TOKEN")
-		if cfg.Token == "" {
-			return nil, fmt.Errorf("Missing token")
-		}
+	}
+	cfg.AuthMode = os.Getenv("PLUGIN_AUTH_MODE")
+	cfg.OIDCToken = os.Getenv("PLUGIN_OIDC_TOKEN")
+	cfg.ImpersonateServiceAccount = os.Getenv("PLUGIN_IMPERSONATE_SERVICE_ACCOUNT")
+	if err := resolveAuthMode(&cfg, needsCredentials); err != nil {
+		return nil, err
+	}
+	// the warnings and errors below may contain values from the config
+	logRedactor.Add(cfg.secretValues()...)
+	for _, w := range cfg.shortSecretWarnings() {
+		log.Printf("Warning: %s", w)
 	}
 
-	if cfg.Runtime == "" {
-		cfg.Runtime = "go111"
+	vars, err := interpolationVars()
+	if err != nil {
+		return nil, err
 	}
 
+	// collect the problems of all functions before failing
+	errs := ConfigErrors{}
+	functions, err := parseFunctions(os.Getenv("PLUGIN_FUNCTIONS"), cfg.Runtime, vars)
+	errs.Append("", err)
+	errs = append(errs, validateImpersonation(&cfg, functions)...)
+
 	switch cfg.Action {
 	case "call":
-		for _, f := range parseFunctions(os.Getenv("PLUGIN_FUNCTIONS"), cfg.Runtime) {
-			cfg.Functions = append(cfg.Functions, f)
-		}
-	case "deploy":
-		for _, f := range parseFunctions(os.Getenv("PLUGIN_FUNCTIONS"), cfg.Runtime) {
-			if isValidFunctionForDeploy(f) {
-				cfg.Functions = append(cfg.Functions, f)
+		cfg.Functions = append(cfg.Functions, functions...)
+	case "deploy", "validate":
+		errs = append(errs, validateEnvSecretNames("env_secret_", cfg.EnvSecrets)...)
+		errs = append(errs, validateEnvSecretNames("build_env_secret_", cfg.BuildEnvSecrets)...)
+		errs = append(errs, validateEnvSecretsSync(&cfg)...)
+		for i, f := range functions {
+			if f.Runtime == autoRuntime {
+				r, err := resolveAutoRuntime(cfg.Dir, f)
+				if err != nil {
+					errs.Append("function "+f.Name, err)
+					continue
+				}
+				functions[i].Runtime = r
+				f.Runtime = r
+			}
+			vars, err := loadEnvVarsFile(cfg.Dir, f)
+			if _, ok := err.(unsupportedYAMLError); ok {
+				log.Printf("Warning: function %s: %s, the file is passed to gcloud unchanged", f.Name, err)
+				functions[i].passEnvVarsFile = true
+				f.passEnvVarsFile = true
+				err = nil
 			}
+			errs.Append("function "+f.Name, err)
+			functions[i].envFileVars = vars
+			f.envFileVars = vars
+			errs.Append("function "+f.Name, validateFunctionForDeploy(f).Err())
+			if cfg.Action == "validate" || !cfg.RefreshRuntimes {
+				// otherwise checked once the catalog is refreshed
+				errs.Append("function "+f.Name, checkRuntimeLifecycle(f.Runtime))
+			}
+			errs.Append("function "+f.Name, validateFunctionLabels(&cfg, f).Err())
+			errs.Append("function "+f.Name, validateEnvSecretScope(cfg.EnvSecrets, f))
+			errs.Append("function "+f.Name, validateUpdateModes(&cfg, f).Err())
+			errs.Append("function "+f.Name, validateEnvVars(&cfg, f).Err())
+			for _, w := range append(functionWarnings(f), secretWarnings(f, cfg.DeployTarget)...) {
+				log.Printf("Warning: function %s: %s", f.Name, w)
+			}
+		}
+		cfg.Functions = functions
+		if cfg.Action == "validate" {
+			errs.Append("", preflightFunctions(&cfg))
 		}
 	case "delete":
-		cfg.Functions = parseFunctions(os.Getenv("PLUGIN_FUNCTIONS"), cfg.Runtime)
+		cfg.Functions = functions
+	}
+	if len(errs) > 0 {
+		return nil, errs
 	}
 
 	if len(cfg.Functions) == 0 && cfg.Action != "list" {
 		return nil, fmt.Errorf("Didn't find any functions")
 	}
 
+	if !needsCredentials {
+		return &cfg, nil
+	}
+
 	if cfg.Project == "" {
 		cfg.Project = getProjectFromToken(cfg.Token)
 		if cfg.Project == "" {
@@ -311,13 +446,14 @@ func CreateExecutionPlan(cfg *Config) (Plan, error) {
 			if f.Data != "" {
 				args = append(args, "--data", f.Data)
 			}
+			args = append(args, impersonationArgs(functionImpersonation(cfg, f))...)
 			res.Steps = append(res.Steps, args)
 		}
 
 	case "deploy":
 		for _, f := range cfg.Functions {
-			if !isValidFunctionForDeploy(f) {
-				return res, fmt.Errorf("invalid config for function: %s", f.Name)
+			if err := append(validateFunctionForDeploy(f), validateFunctionLabels(cfg, f)...).Err(); err != nil {
+				return res, fmt.Errorf("invalid config for function %s: %s", f.Name, err)
 			}
 
 			args := append(baseArgs, f.Name, "--runtime", f.Runtime)
@@ -331,6 +467,19 @@ func CreateExecutionPlan(cfg *Config) (Plan, error) {
 				args = append(args, "--trigger-topic", f.TriggerResource)
 			case "event":
 				args = append(args, "--trigger-event", f.TriggerEvent, "--trigger-resource="+f.TriggerResource)
+			case "eventarc":
+				for _, k := range sortedKeys(f.EventFilters) {
+					args = append(args, "--trigger-event-filters", k+"="+f.EventFilters[k])
+				}
+				for _, k := range sortedKeys(f.EventFiltersPathPattern) {
+					args = append(args, "--trigger-event-filters-path-pattern", k+"="+f.EventFiltersPathPattern[k])
+				}
+				if f.TriggerLocation != "" {
+					args = append(args, "--trigger-location", f.TriggerLocation)
+				}
+				if f.TriggerServiceAccount != "" {
+					args = append(args, "--trigger-service-account", f.TriggerServiceAccount)
+				}
 			}
 
 			if f.AllowUnauthenticated {
@@ -363,34 +512,11 @@ func CreateExecutionPlan(cfg *Config) (Plan, error) {
 			if f.ServiceAccount != "" {
 				args = append(args, "--service-account", f.ServiceAccount)
 			}
-			if len(cfg.EnvSecrets) > 0 || len(f.Environment) > 0 {
-				e := make([]string, len(cfg.EnvSecrets))
-				copy(e, cfg.EnvSecrets)
-
-				if len(f.Environment) > 0 {
-					for k, v := range f.Environment[0] {
-						e = append(e, fmt.Sprintf(`%s=%s`, k, v))
-					}
-				}
-
-				envStr := "^" + f.EnvironmentDelimiter + "^" + strings.Join(e, f.EnvironmentDelimiter)
-				args = append(args, "--set-env-vars", envStr)
-			}
-			if f.EnvironmentVarsFile != "" {
-				args = append(args, "--env-vars-file", f.EnvironmentVarsFile)
-			}
+			args = append(args, envArgs(cfg, f)...)
 			if f.VpcConnector != "" {
 				args = append(args, "--vpc-connector", f.VpcConnector)
 			}
-			if len(f.Secrets) > 0 {
-				e := make([]string, 0)
-				for k, v := range f.Secrets {
-					e = append(e, fmt.Sprintf(`%s=%s`, k, v))
-				}
-
-				secretsStr := "^" + f.EnvironmentDelimiter + "^" + strings.Join(e, f.EnvironmentDelimiter)
-				args = append(args, "--set-secrets", secretsStr)
-			}
+			args = append(args, secretsArgs(f)...)
 
 			if f.IngressSettings != "" {
 				args = append(args, "--ingress-settings", f.IngressSettings)
@@ -400,6 +526,12 @@ func CreateExecutionPlan(cfg *Config) (Plan, error) {
 				args = append(args, "--egress-settings", f.EgressSettings)
 			}
 
+			args = append(args, scalingArgs(f)...)
+
+			args = append(args, buildArgs(cfg, f)...)
+			args = append(args, labelArgs(cfg, f)...)
+			args = append(args, impersonationArgs(functionImpersonation(cfg, f))...)
+
 			res.Steps = append(res.Steps, args)
 		}
 
@@ -409,11 +541,12 @@ func CreateExecutionPlan(cfg *Config) (Plan, error) {
 			if f.Region != "" {
 				args = append(args, "--region", f.Region)
 			}
+			args = append(args, impersonationArgs(functionImpersonation(cfg, f))...)
 			res.Steps = append(res.Steps, args)
 		}
 
 	case "list":
-		res.Steps = append(res.Steps, baseArgs)
+		res.Steps = append(res.Steps, append(baseArgs, impersonationArgs(cfg.ImpersonateServiceAccount)...))
 
 	default:
 		return res, fmt.Errorf("action: %s not implemented yet", cfg.Action)
@@ -433,48 +566,210 @@ func ExecutePlan(e *Env, plan Plan) error {
 }
 
 func runConfig(cfg *Config) error {
-	plan, err := CreateExecutionPlan(cfg)
-	if err != nil {
+	// fail on invalid configs before doing anything
+	if _, err := CreateExecutionPlan(cfg); err != nil {
 		return err
 	}
 
+	if cfg.Action == "deploy" {
+		defer cfg.removeArchives()
+		if err := preflightFunctions(cfg); err != nil {
+			return err
+		}
+	}
+
 	e := NewEnv(cfg.Dir, os.Environ(), os.Stdout, os.Stderr, cfg.DryRun, cfg.Verbose)
+	e.Mask(cfg.secretValues()...)
 
 	if err := e.Run("gcloud", "version"); err != nil {
 		return fmt.Errorf("error: %s\n", err)
 	}
 
-	if err := e.Run("gcloud", "auth", "activate-service-account", "--key-file", TmpTokenFileLocation); err != nil {
+	if args := authArgs(cfg); args != nil {
+		if err := e.Run("gcloud", args...); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("Using the account of the metadata server")
+	}
+
+	if cfg.Action == "deploy" && cfg.RefreshRuntimes {
+		if err := refreshRuntimeCatalog(e, runtimeCatalogRegion(cfg.Functions), impersonationArgs(cfg.ImpersonateServiceAccount)...); err != nil {
+			return err
+		}
+		// the lifecycle dates might have changed
+		errs := ConfigErrors{}
+		for _, f := range cfg.Functions {
+			errs.Append("function "+f.Name, checkRuntimeLifecycle(f.Runtime))
+		}
+		if err := errs.Err(); err != nil {
+			return err
+		}
+	}
+
+	// the APIs are called as the account the gcloud commands of the
+	// function run as
+	tokens := accessTokens(cfg, e)
+
+	if cfg.Action == "deploy" && cfg.VerifySecrets {
+		if cfg.DryRun {
+			log.Printf("Dry run, not verifying secrets")
+		} else {
+			err := verifySecrets(cfg, func(f Function) (*SecretManagerClient, error) {
+				token, err := tokens(f)
+				if err != nil {
+					return nil, fmt.Errorf("can't get access token to verify the secrets: %s", err)
+				}
+				return NewSecretManagerClient(secretManagerEndpoint(), token), nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if cfg.Action == "deploy" && cfg.EnvSecretsMode == envSecretsModeSecretManager && len(cfg.EnvSecrets) > 0 {
+		err := syncEnvSecrets(cfg, func(f Function) (*SecretManagerClient, error) {
+			token, err := tokens(f)
+			if err != nil {
+				return nil, fmt.Errorf("can't get access token to store the env secrets: %s", err)
+			}
+			return NewSecretManagerClient(secretManagerEndpoint(), token), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.Action == "deploy" && cfg.StagingBucket != "" {
+		err := stageSources(cfg, func(f Function) (*StorageClient, error) {
+			token, err := tokens(f)
+			if err != nil {
+				return nil, fmt.Errorf("can't get access token for the staging bucket: %s", err)
+			}
+			return NewStorageClient(storageEndpoint(), token), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// the sources of the functions point to the staged archives and the env
+	// secrets to Secret Manager now
+	plan, err := CreateExecutionPlan(cfg)
+	if err != nil {
+		return err
+	}
+	if err := ExecutePlan(e, plan); err != nil {
 		return err
 	}
 
-	return ExecutePlan(e, plan)
+	if cfg.Action == "deploy" {
+		log.Print(deployReport(cfg))
+	}
+	return nil
+}
+
+// accessToken returns an access token of the active gcloud account, or the
+// impersonated service account if it's set, for the APIs that are called
+// directly, it's masked in the output.
+func accessToken(e *Env, account string) (string, error) {
+	args := append([]string{"auth", "print-access-token"}, impersonationArgs(account)...)
+	token, err := e.Output("gcloud", args...)
+	if err != nil {
+		return "", err
+	}
+	e.Mask(token)
+	return token, nil
+}
+
+// accessTokens returns a func that gets the access token for the API calls
+// of a function, they are made as the service account its gcloud commands
+// run as. Every token is only requested once.
+func accessTokens(cfg *Config, e *Env) func(f Function) (string, error) {
+	tokens := map[string]string{}
+	return func(f Function) (string, error) {
+		account := functionImpersonation(cfg, f)
+		if token, ok := tokens[account]; ok {
+			return token, nil
+		}
+		token, err := accessToken(e, account)
+		if err != nil {
+			return "", err
+		}
+		tokens[account] = token
+		return token, nil
+	}
+}
+
+// deployReport lists the deployed functions with their source and, for
+// staged sources, the digest of the archive.
+func deployReport(cfg *Config) string {
+	lines := []string{fmt.Sprintf("Deployed %d function(s):", len(cfg.Functions))}
+	for _, f := range cfg.Functions {
+		src := f.Source
+		if src == "" {
+			src = "."
+		}
+		line := fmt.Sprintf("  %s  source: %s", f.Name, src)
+		if f.sourceDigest != "" {
+			line += "  sha256: " + f.sourceDigest
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
 }
 
 type Env struct {
 	dir     string
 	env     []string
-	stdout  io.Writer
-	stderr  io.Writer
+	stdout  *RedactWriter
+	stderr  *RedactWriter
 	dryRun  bool
 	verbose bool
+
+	// values that are masked in the logged commands and their output
+	redactor *Redactor
 }
 
 func NewEnv(dir string, env []string, stdout, stderr io.Writer, dryRun bool, verbose bool) *Env {
+	r := &Redactor{}
 	return &Env{
-		dir:     dir,
-		env:     env,
-		stdout:  stdout,
-		stderr:  stderr,
-		dryRun:  dryRun,
-		verbose: verbose,
+		dir:      dir,
+		env:      env,
+		stdout:   r.Writer(stdout),
+		stderr:   r.Writer(stderr),
+		dryRun:   dryRun,
+		verbose:  verbose,
+		redactor: r,
 	}
 }
 
-func (e *Env) Run(name string, arg ...string) error {
-	if e.verbose {
-		log.Printf("Running: %s %#v", name, arg)
+// Mask hides the values in the logged commands and their output, and in
+// the rest of the log.
+func (e *Env) Mask(values ...string) {
+	e.redactor.Add(values...)
+	logRedactor.Add(values...)
+}
+
+// logCommand logs the commands that are run in verbose mode and the ones
+// that would be run in dry runs.
+func (e *Env) logCommand(name string, arg []string) {
+	// the arguments are masked before %#v escapes quotes and backslashes
+	masked := make([]string, len(arg))
+	for i, a := range arg {
+		masked[i] = e.redactor.Mask(a)
+	}
+	switch {
+	case e.dryRun:
+		log.Printf("Dry run, not running: %s %#v", name, masked)
+	case e.verbose:
+		log.Printf("Running: %s %#v", name, masked)
 	}
+}
+
+func (e *Env) Run(name string, arg ...string) error {
+	e.logCommand(name, arg)
 	if e.dryRun {
 		return nil
 	}
@@ -483,10 +778,35 @@ func (e *Env) Run(name string, arg ...string) error {
 	cmd.Env = e.env
 	cmd.Stdout = e.stdout
 	cmd.Stderr = e.stderr
+	defer e.flush()
 	return cmd.Run()
 }
 
+// flush writes the output of the last command that didn't end with a newline.
+func (e *Env) flush() {
+	e.stdout.Flush()
+	e.stderr.Flush()
+}
+
+// Output runs a command like Run but returns its output instead of writing
+// it to stdout. Nothing is run in dry runs.
+func (e *Env) Output(name string, arg ...string) (string, error) {
+	e.logCommand(name, arg)
+	if e.dryRun {
+		return "", nil
+	}
+	cmd := exec.Command(name, arg...)
+	cmd.Dir = e.dir
+	cmd.Env = e.env
+	cmd.Stderr = e.stderr
+	defer e.flush()
+	b, err := cmd.Output()
+	return strings.TrimSpace(string(b)), err
+}
+
 func main() {
+	log.SetOutput(logRedactor.Writer(os.Stderr))
+
 	if BuildTag == "" {
 		BuildTag = "[not-tagged]"
 	}
@@ -500,19 +820,30 @@ func main() {
 		return
 	}
 
+	if flag.Arg(0) == "schema" {
+		if err := writeSchema(os.Stdout); err != nil {
+			log.Fatalf("writeSchema() err: %s", err)
+		}
+		return
+	}
+
 	cfg, err := parseConfig()
 	if err != nil {
 		log.Fatalf("parseConfig() err: %s", err)
 		return
 	}
 
-	if err := ioutil.WriteFile(TmpTokenFileLocation, []byte(cfg.Token), 0600); err != nil {
-		log.Fatalf("Error writing token file: %s", err)
+	if cfg.Action == "validate" {
+		cfg.removeArchives()
+		log.Printf("Config is valid, checked %d function(s)", len(cfg.Functions))
+		return
 	}
 
-	defer func() {
-		os.Remove(TmpTokenFileLocation)
-	}()
+	removeCredentials, err := writeCredentials(cfg)
+	if err != nil {
+		log.Fatalf("Error writing token file: %s", err)
+	}
+	defer removeCredentials()
 
 	if err := runConfig(cfg); err != nil {
 		log.Fatalf("runConfig() err: %s", err)
@@ -5,8 +5,18 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
+// testDate is the date the tests run at, the runtimes used by the tests
+// weren't deprecated yet.
+var testDate = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func TestMain(m *testing.M) {
+	now = func() time.Time { return testDate }
+	os.Exit(m.Run())
+}
+
 var (
 	validGCPKey = `
 {
@@ -35,7 +45,7 @@ var (
 func TestParseFunctionsForDeploy(t *testing.T) {
 	for _, tst := range []string{
 		"[{\"TransferFile\":[{\"trigger\":\"http\"}]}]",
-		"[{\"TransferFileGen2\":[{\"trigger\":\"http\",\"gen2\":true}]}]",
+		"[{\"TransferFileGen2\":[{\"trigger\":\"http\",\"gen2\":true,\"runtime\":\"go121\"}]}]",
 		"[{\"TransferFilePublic\":[{\"trigger\":\"http\",\"allow_unauthenticated\":true}]}]",
 		"[{\"TransferFilePrivate\":[{\"trigger\":\"http\",\"allow_unauthenticated\":false}]}]",
 		"[{\"TransferFile\":[{\"trigger\":\"http\",\"memory\":\"2048MB\"}]}]",
@@ -43,18 +53,22 @@ func TestParseFunctionsForDeploy(t *testing.T) {
 		"[{\"Func654\":[{\"trigger\":\"topic\",\"trigger_resource\":\"topic/my-bucket\",\"memory\":\"512MB\"}]}]",
 		"[{\"FuncNew\":[{\"trigger\":\"event\",\"trigger_event\":\"providers/cloud.storage/eventTypes/object.change\",\"trigger_resource\":\"gs://bucket321\"}]}]",
 		"[{\"FuncNew\":[{\"trigger\":\"event\",\"trigger_event\":\"providers/cloud.storage/eventTypes/object.change\",\"trigger_resource\":\"gs://bucket321\"}]}]",
-		"[{\"FuncSecret\":[{\"trigger\":\"http\",\"secrets\":{\"/mount/path\":\"top_secret\",\"ENV_VAR\":\"top_secret\"}}]}]",
+		"[{\"FuncSecret\":[{\"trigger\":\"http\",\"secrets\":{\"/mount/path\":\"top_secret:latest\",\"ENV_VAR\":\"top_secret:1\"}}]}]",
 		"[{\"FuncIngress\":[{\"trigger\":\"http\",\"ingress_settings\":\"internal-only\"}]}]",
 		"[{\"FuncEgress\":[{\"trigger\":\"http\",\"egress_settings\":\"all\"}]}]",
 	} {
-		functions := parseFunctions(tst, "go111")
+		functions, err := parseFunctions(tst, "go111", nil)
+		if err != nil {
+			t.Errorf("parseFunctions(%s) err: %s", tst, err)
+			return
+		}
 		if len(functions) == 0 {
 			t.Errorf("not enough functions")
 			return
 		}
 		for _, f := range functions {
-			if !isValidFunctionForDeploy(f) {
-				t.Errorf("found an invalid function: %s", f.Name)
+			if errs := validateFunctionForDeploy(f); len(errs) > 0 {
+				t.Errorf("found an invalid function: %s, err: %s", f.Name, errs)
 			}
 		}
 	}
@@ -64,7 +78,11 @@ func TestParseFunctionsForDelete(t *testing.T) {
 	for _, tst := range []string{
 		"TransferFile,ProcessEvents4,ThirdFunc",
 	} {
-		functions := parseFunctions(tst, "go111")
+		functions, err := parseFunctions(tst, "go111", nil)
+		if err != nil {
+			t.Errorf("parseFunctions(%s) err: %s", tst, err)
+			return
+		}
 		if len(functions) == 0 {
 			t.Errorf("not enough functions")
 			return
@@ -79,9 +97,12 @@ func TestParseFunctionsforDeploy(t *testing.T) {
 		"[{\"HeyNow123\":[{\"trigger\":\"bucket\",\"trigger_resource\":\"\",\"memory\":\"512MB\"}]}]",
 		"[{\"FuncNew\":[{\"trigger\":\"event\",\"trigger_event\":\"\",\"trigger_resource\":\"gs://bucket321\"}]}]",
 	} {
-		functions := parseFunctions(tst, "go111")
+		functions, err := parseFunctions(tst, "go111", nil)
+		if err != nil {
+			continue
+		}
 		for _, f := range functions {
-			if isValidFunctionForDeploy(f) {
+			if len(validateFunctionForDeploy(f)) == 0 {
 				t.Errorf("Should have rejected function: %s", f.Name)
 			}
 		}
@@ -187,6 +208,26 @@ func TestParseConfig(t *testing.T) {
 			Env:               map[string]string{"PLUGIN_ACTION": "deploy", "PLUGIN_TOKEN": validGCPKey, "PLUGIN_FUNCTIONS": "[{\"TransferFile\":[{\"trigger\":\"http\",\"runtime\":\"go111\",\"memory\":\"2048MB\", \"ingress_settings\":\"invalid\"}]}]"},
 			expectedProjectId: "my-project-id",
 		},
+		{
+			expectedToBeOk:    true,
+			Env:               map[string]string{"PLUGIN_ACTION": "deploy", "PLUGIN_TOKEN": validGCPKey, "PLUGIN_FUNCTIONS": pf, "PLUGIN_MAX_SOURCE_SIZE": "50MB", "PLUGIN_FAIL_ON_SECRET_FILES": "true"},
+			expectedProjectId: "my-project-id",
+		},
+		{
+			expectedToBeOk:    false,
+			Env:               map[string]string{"PLUGIN_ACTION": "deploy", "PLUGIN_TOKEN": validGCPKey, "PLUGIN_FUNCTIONS": pf, "PLUGIN_MAX_SOURCE_SIZE": "lots"},
+			expectedProjectId: "my-project-id",
+		},
+		{
+			expectedToBeOk:    true,
+			Env:               map[string]string{"PLUGIN_ACTION": "deploy", "PLUGIN_TOKEN": validGCPKey, "DRONE_COMMIT_SHA": "abc123", "PLUGIN_FUNCTIONS": "[{\"TransferFile\":[{\"trigger\":\"http\",\"runtime\":\"go111\",\"environment\":[{\"BUILD_HASH\":\"${DRONE_COMMIT_SHA}\"}]}]}]"},
+			expectedProjectId: "my-project-id",
+		},
+		{
+			expectedToBeOk:    false,
+			Env:               map[string]string{"PLUGIN_ACTION": "deploy", "PLUGIN_TOKEN": validGCPKey, "PLUGIN_FUNCTIONS": "[{\"TransferFile\":[{\"trigger\":\"http\",\"runtime\":\"go111\",\"environment\":[{\"BUILD_HASH\":\"${DRONE_COMMIT_SHA}\"}]}]}]"},
+			expectedProjectId: "my-project-id",
+		},
 	} {
 		os.Clearenv()
 
@@ -247,7 +288,7 @@ func TestExecutePlan(t *testing.T) {
 					},
 					{
 						Name:    "ProcessEvents",
-						Runtime: "go111",
+						Runtime: "go121",
 						Trigger: "http",
 						Memory:  "512MB",
 						Timeout: "20s",
@@ -291,7 +332,7 @@ func TestExecutePlan(t *testing.T) {
 			expectedToBeOk: true,
 			expectedPlan: [][]string{
 				{"--quiet", "functions", "deploy", "--project", pId, "--verbosity", "info", "ProcessEvents", "--runtime", "go111", "--trigger-http", "--allow-unauthenticated", "--memory", "512MB", "--timeout", "20s"},
-				{"--quiet", "functions", "deploy", "--project", pId, "--verbosity", "info", "ProcessEvents", "--runtime", "go111", "--trigger-http", "--gen2", "--memory", "512MB", "--timeout", "20s"},
+				{"--quiet", "functions", "deploy", "--project", pId, "--verbosity", "info", "ProcessEvents", "--runtime", "go121", "--trigger-http", "--gen2", "--memory", "512MB", "--timeout", "20s"},
 				{"--quiet", "functions", "deploy", "--project", pId, "--verbosity", "info", "ProcessPubSub", "--runtime", "python37", "--trigger-topic", "topic/emails/filtered", "--memory", "2048MB", "--timeout", "20s"},
 				{"--quiet", "functions", "deploy", "--project", pId, "--verbosity", "info", "ProcessNews", "--runtime", "go111", "--trigger-bucket", "gs://bucket/files/cool", "--source", "src/", "--region", "us-east1", "--retry"},
 				{"--quiet", "functions", "deploy", "--project", pId, "--verbosity", "info", "ProcessMoreEvents", "--runtime", "go111", "--trigger-event", "my.event", "--trigger-resource=my.trigger.resource", "--entry-point", "FuncEntryPoint"},
@@ -299,6 +340,28 @@ func TestExecutePlan(t *testing.T) {
 			},
 		},
 
+		{
+			cfg: Config{
+				Action: "deploy",
+				Functions: Functions{
+					{
+						Name:                    "OnUserCreated",
+						Runtime:                 "go121",
+						Trigger:                 "eventarc",
+						Gen2:                    true,
+						EventFilters:            map[string]string{"type": "google.cloud.firestore.document.v1.created", "database": "(default)"},
+						EventFiltersPathPattern: map[string]string{"document": "users/{userId}"},
+						TriggerLocation:         "nam5",
+						TriggerServiceAccount:   "trigger@project.iam.gserviceaccount.com",
+					},
+				},
+			},
+			expectedToBeOk: true,
+			expectedPlan: [][]string{
+				{"--quiet", "functions", "deploy", "--project", pId, "--verbosity", "info", "OnUserCreated", "--runtime", "go121", "--trigger-event-filters", "database=(default)", "--trigger-event-filters", "type=google.cloud.firestore.document.v1.created", "--trigger-event-filters-path-pattern", "document=users/{userId}", "--trigger-location", "nam5", "--trigger-service-account", "trigger@project.iam.gserviceaccount.com", "--gen2"},
+			},
+		},
+
 		{
 			cfg: Config{
 				Action:     "deploy",
@@ -351,13 +414,16 @@ func TestExecutePlan(t *testing.T) {
 						Trigger:              "http",
 						Memory:               "512MB",
 						AllowUnauthenticated: false,
+						EnvironmentDelimiter: ":|:",
+						Environment:          []map[string]string{{"MODE": "inline"}},
 						EnvironmentVarsFile:  ".env.yaml",
+						envFileVars:          map[string]string{"MODE": "file", "FROM_FILE": "yes"},
 					},
 				},
 			},
 			expectedToBeOk: true,
 			expectedPlan: [][]string{
-				{"--quiet", "functions", "deploy", "--project", pId, "--verbosity", "info", "ProcessEvents", "--runtime", "go111", "--trigger-http", "--memory", "512MB", "--env-vars-file", ".env.yaml"},
+				{"--quiet", "functions", "deploy", "--project", pId, "--verbosity", "info", "ProcessEvents", "--runtime", "go111", "--trigger-http", "--memory", "512MB", "--set-env-vars", "^:|:^FROM_FILE=yes:|:MODE=inline"},
 			},
 		},
 
@@ -558,6 +624,18 @@ func TestEnvironRun(t *testing.T) {
 	}
 }
 
+func TestEnvironOutput(t *testing.T) {
+	e := NewEnv("/tmp", []string{"ABC=123"}, &bytes.Buffer{}, &bytes.Buffer{}, false, false)
+	if out, err := e.Output("/bin/echo", "sup"); err != nil || out != "sup" {
+		t.Errorf("Output() got: %q   err: %v", out, err)
+	}
+
+	e = NewEnv("/tmp", nil, &bytes.Buffer{}, &bytes.Buffer{}, true, false)
+	if out, err := e.Output("/bin/echo", "sup"); err != nil || out != "" {
+		t.Errorf("Output() in dry run got: %q   err: %v", out, err)
+	}
+}
+
 func TestGetProjectFromToken(t *testing.T) {
 	if id := getProjectFromToken(validGCPKey); id != "my-project-id" {
 		t.Errorf("Wrong project id, got: %s", id)
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var entryPointRegex = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$.]*$`)
+
+// identifierEntryPointFamilies are the runtime families whose entry point
+// has to be an identifier, e.g. Node.js can export functions under any name
+// with exports['transfer-file'].
+var identifierEntryPointFamilies = []string{"go", "java", "dotnet"}
+
+// isRemoteSource returns true for sources that gcloud fetches itself, e.g.
+// zip files in GCS or Cloud Source Repositories.
+func isRemoteSource(s string) bool {
+	return strings.HasPrefix(s, "gs://") || strings.HasPrefix(s, "https://")
+}
+
+// sourceDir returns the local directory of the source of f, relative paths
+// are resolved against dir which is where gcloud runs.
+func sourceDir(dir string, f Function) string {
+	if filepath.IsAbs(f.Source) {
+		return f.Source
+	}
+	return filepath.Join(dir, f.Source)
+}
+
+// entryPoint returns the name of the function in the source code.
+func entryPoint(f Function) string {
+	if f.EntryPoint != "" {
+		return f.EntryPoint
+	}
+	return f.Name
+}
+
+// preflightFunctions runs all checks of the functions that can be done
+// locally, without credentials or network access, and reports all problems.
+// The entry point and dependency checks can't follow every way to export a
+// function or import a package, so outside of the validate action they only
+// log warnings.
+func preflightFunctions(cfg *Config) error {
+	errs := ConfigErrors{}
+	checkedArchives := map[string]bool{}
+	for _, f := range cfg.Functions {
+		err := preflightFunction(cfg.Dir, f, cfg.Action == "validate")
+		errs.Append("function "+f.Name, err)
+		if isZipSource(f.Source) && cfg.StagingBucket == "" {
+			errs.Add("function %s: source %s is a zip file, set staging_bucket to deploy it", f.Name, f.Source)
+		}
+		if err != nil || isRemoteSource(f.Source) {
+			continue
+		}
+
+		// functions often share their source, only check each archive once
+		src := sourceDir(cfg.Dir, f)
+		if !checkedArchives[src] {
+			checkedArchives[src] = true
+			errs.Append("function "+f.Name, checkSourceArchive(cfg, src))
+		}
+	}
+	return errs.Err()
+}
+
+func preflightFunction(dir string, f Function, strict bool) error {
+	errs := ConfigErrors{}
+
+	validEntryPoint := !containsString(identifierEntryPointFamilies, runtimeFamily(f.Runtime)) || entryPointRegex.MatchString(entryPoint(f))
+	if ep := entryPoint(f); !validEntryPoint {
+		if f.EntryPoint == "" {
+			errs.Add("function name %q can't be used as entry point, set entrypoint", ep)
+		} else {
+			errs.Add("invalid entrypoint %q", ep)
+		}
+	}
+
+	if isRemoteSource(f.Source) {
+		return errs.Err()
+	}
+
+	src := sourceDir(dir, f)
+	if isZipSource(f.Source) {
+		fi, err := os.Stat(src)
+		switch {
+		case os.IsNotExist(err):
+			errs.Add("source zip file %s doesn't exist", src)
+		case err != nil:
+			errs.Add("source %s: %s", src, err)
+		case !fi.Mode().IsRegular():
+			errs.Add("source %s is not a file", src)
+		default:
+			errs.Append("", checkZipArtifact(src, f))
+		}
+		return errs.Err()
+	}
+
+	fi, err := os.Stat(src)
+	switch {
+	case os.IsNotExist(err):
+		errs.Add("source directory %s doesn't exist", src)
+	case err != nil:
+		errs.Add("source %s: %s", src, err)
+	case !fi.IsDir():
+		errs.Add("source %s is not a directory", src)
+	default:
+		errs.Append("", checkSource(src, f))
+		heuristic := ConfigErrors{}
+		heuristic.Append("", checkDependencies(src, f))
+		if validEntryPoint {
+			heuristic.Append("", checkEntryPoint(src, f))
+		}
+		if strict {
+			errs = append(errs, heuristic...)
+			break
+		}
+		for _, err := range heuristic {
+			log.Printf("Warning: function %s: %s", f.Name, err)
+		}
+	}
+
+	return errs.Err()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the files, given as path => content, below dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for p, content := range files {
+		p = filepath.Join(dir, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("MkdirAll() err: %s", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() err: %s", err)
+		}
+	}
+}
+
+func TestPreflightFunctions(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"src/function.go": "package function\n",
+		"not-a-dir":       "",
+	})
+
+	for _, tst := range []struct {
+		f              Function
+		expectedErrors []string
+	}{
+		{f: Function{Name: "Func", Source: "src"}},
+		{f: Function{Name: "Func", Source: filepath.Join(dir, "src")}},
+		{f: Function{Name: "Func", Source: "gs://bucket/source.zip"}},
+		{f: Function{Name: "my-func", EntryPoint: "MyFunc", Source: "src"}},
+		{f: Function{Name: "Func", EntryPoint: "com.example.Func", Source: "src"}},
+		{f: Function{Name: "Func", Source: "missing"}, expectedErrors: []string{"source directory " + filepath.Join(dir, "missing") + " doesn't exist"}},
+		{f: Function{Name: "Func", Source: "not-a-dir"}, expectedErrors: []string{"is not a directory"}},
+		{f: Function{Name: "my-func", Runtime: "java17", Source: "src"}, expectedErrors: []string{`function name "my-func" can't be used as entry point, set entrypoint`}},
+		{f: Function{Name: "Func", Runtime: "dotnet6", EntryPoint: "not valid", Source: "src"}, expectedErrors: []string{`invalid entrypoint "not valid"`}},
+		{f: Function{Name: "my-func", Runtime: "java17", Source: "missing"}, expectedErrors: []string{"can't be used as entry point", "doesn't exist"}},
+		// only some runtimes require an identifier
+		{f: Function{Name: "my-func", Runtime: "ruby32", Source: "src"}},
+	} {
+		err := preflightFunctions(&Config{Dir: dir, Functions: []Function{tst.f}})
+		if len(tst.expectedErrors) == 0 {
+			if err != nil {
+				t.Errorf("preflightFunctions(%#v) failed, err: %s", tst.f, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("preflightFunctions(%#v) should have failed", tst.f)
+			continue
+		}
+		for _, e := range tst.expectedErrors {
+			if !strings.Contains(err.Error(), e) {
+				t.Errorf("preflightFunctions(%#v) expected error to contain %q, got: %s", tst.f, e, err)
+			}
+		}
+	}
+}
+
+func TestPreflightEntryPointWarnings(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"src/main.py": "def other(request):\n    pass\n", "src/requirements.txt": ""})
+	f := Function{Name: "hello", Runtime: "python311", Trigger: "http", Source: "src"}
+
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	if err := preflightFunctions(&Config{Action: "deploy", Dir: dir, Functions: Functions{f}}); err != nil {
+		t.Errorf("preflightFunctions() for deploy failed, err: %s", err)
+	}
+	if !strings.Contains(buf.String(), "Warning: function hello: entry point hello not found") {
+		t.Errorf("expected entry point warning, got: %s", buf.String())
+	}
+
+	err := preflightFunctions(&Config{Action: "validate", Dir: dir, Functions: Functions{f}})
+	if err == nil || !strings.Contains(err.Error(), "function hello: entry point hello not found") {
+		t.Errorf("preflightFunctions() for validate expected entry point error, got: %v", err)
+	}
+}
+
+func TestPreflightNodeEntryPointName(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"src/package.json": `{"main": "index.js"}`,
+		"src/index.js":     "exports['transfer-file'] = (req, res) => {};\n",
+	})
+	f := Function{Name: "transfer-file", Runtime: "nodejs20", Trigger: "http", Source: "src"}
+
+	if err := preflightFunctions(&Config{Action: "validate", Dir: dir, Functions: Functions{f}}); err != nil {
+		t.Errorf("preflightFunctions() failed, err: %s", err)
+	}
+
+	f.Name = "other-file"
+	err := preflightFunctions(&Config{Action: "validate", Dir: dir, Functions: Functions{f}})
+	if err == nil || !strings.Contains(err.Error(), "entry point other-file is not exported") {
+		t.Errorf("preflightFunctions() expected entry point error, got: %v", err)
+	}
+}
+
+func TestPreflightDependencyWarnings(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"src/package.json": `{"main": "index.js"}`,
+		"src/index.js":     "// the client used to be: require('old-client')\nexports.hello = (req, res) => {};\n",
+	})
+	f := Function{Name: "hello", Runtime: "nodejs20", Trigger: "http", Source: "src"}
+
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	if err := preflightFunctions(&Config{Action: "deploy", Dir: dir, Functions: Functions{f}}); err != nil {
+		t.Errorf("preflightFunctions() for deploy failed, err: %s", err)
+	}
+	if !strings.Contains(buf.String(), "Warning: function hello: dependency old-client is missing in package.json") {
+		t.Errorf("expected dependency warning, got: %s", buf.String())
+	}
+
+	err := preflightFunctions(&Config{Action: "validate", Dir: dir, Functions: Functions{f}})
+	if err == nil || !strings.Contains(err.Error(), "function hello: dependency old-client is missing in package.json") {
+		t.Errorf("preflightFunctions() for validate expected dependency error, got: %v", err)
+	}
+}
+
+func TestParseConfigValidate(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"src/function.go": goHTTPFunction, "src/go.mod": "module example.com/function\n\ngo 1.21\n"})
+
+	os.Clearenv()
+	os.Setenv("PLUGIN_ACTION", "validate")
+	os.Setenv("DRONE_WORKSPACE", dir)
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"Func":[{"trigger":"http","runtime":"go121","source":"src"}]}]`)
+
+	cfg, err := parseConfig()
+	if err != nil {
+		t.Fatalf("parseConfig() without token failed, err: %s", err)
+	}
+	if len(cfg.Functions) != 1 {
+		t.Errorf("expected one function, got: %#v", cfg.Functions)
+	}
+
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"Func":[{"trigger":"http","runtime":"go121","source":"missing"}]},{"Other":[{"trigger":"topic","runtime":"go121","source":"src"}]}]`)
+	_, err = parseConfig()
+	if err == nil {
+		t.Fatalf("parseConfig() should have failed")
+	}
+	for _, e := range []string{"found 3 problems", "function Other: missing trigger resource", "function Func: source directory", "function Other: entry point Other not found"} {
+		if !strings.Contains(err.Error(), e) {
+			t.Errorf("expected error to contain %q, got: %s", e, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fields of the token that must not show up in the output
+var tokenSecretFields = []string{"private_key", "private_key_id", "client_secret", "refresh_token"}
+
+// minRedactLength is the length below which values aren't masked, masking
+// them would hide unrelated parts of the output like numbers.
+const minRedactLength = 4
+
+// logRedactor masks the secrets in the output of the log package, main
+// installs it before the config is parsed so every line is covered.
+var logRedactor = &Redactor{}
+
+// maxRedactBuffer is the size up to which output without a newline is held
+// back, longer lines are written in parts.
+const maxRedactBuffer = 64 * 1024
+
+// secretValues returns the values of the secrets that are passed to gcloud,
+// they must not show up in the output.
+func (cfg *Config) secretValues() []string {
+	res := []string{}
+	for _, l := range [][]string{cfg.EnvSecrets, cfg.BuildEnvSecrets} {
+		for _, s := range l {
+			if kv := strings.SplitN(s, "=", 2); len(kv) == 2 && kv[1] != "" {
+				res = append(res, kv[1])
+			}
+		}
+	}
+	if cfg.OIDCToken != "" {
+		res = append(res, cfg.OIDCToken)
+	}
+	return append(res, tokenSecretValues(cfg.Token)...)
+}
+
+// shortSecretWarnings returns warnings for the env secrets that are too
+// short to be masked in the output.
+func (cfg *Config) shortSecretWarnings() []string {
+	res := []string{}
+	for _, l := range [][]string{cfg.EnvSecrets, cfg.BuildEnvSecrets} {
+		for _, s := range l {
+			if kv := strings.SplitN(s, "=", 2); len(kv) == 2 && kv[1] != "" && len(kv[1]) < minRedactLength {
+				res = append(res, fmt.Sprintf("secret %s is shorter than %d characters and isn't masked in the output", kv[0], minRedactLength))
+			}
+		}
+	}
+	return res
+}
+
+// tokenSecretValues returns the values of the secret fields of the token.
+// Output is masked line by line, so the lines of multi-line values like the
+// private key are masked one by one, as is their json encoded form.
+func tokenSecretValues(token string) []string {
+	data := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(token), &data); err != nil {
+		return nil
+	}
+
+	res := []string{}
+	for _, field := range tokenSecretFields {
+		v, ok := data[field].(string)
+		if !ok || v == "" {
+			continue
+		}
+		res = append(res, v)
+		if !strings.Contains(v, "\n") {
+			continue
+		}
+		if b, err := json.Marshal(v); err == nil {
+			res = append(res, strings.Trim(string(b), `"`))
+		}
+		for _, l := range strings.Split(v, "\n") {
+			// short lines are just the end of the key
+			if l = strings.TrimSpace(l); len(l) >= minRedactLength {
+				res = append(res, l)
+			}
+		}
+	}
+	return res
+}
+
+// maskSecrets replaces the secret values in s with ****.
+func maskSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, "****")
+		}
+	}
+	return s
+}
+
+// Redactor holds the secret values that are masked in everything written
+// through its writers.
+type Redactor struct {
+	mu      sync.Mutex
+	secrets []string
+}
+
+// Add registers more values to mask, values shorter than minRedactLength
+// are skipped.
+func (r *Redactor) Add(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range values {
+		if len(v) >= minRedactLength && !containsString(r.secrets, v) {
+			r.secrets = append(r.secrets, v)
+		}
+	}
+	// longer values first so a secret containing another one is masked as a whole
+	sort.SliceStable(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+}
+
+// Mask replaces the registered values in s with ****.
+func (r *Redactor) Mask(s string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return maskSecrets(s, r.secrets)
+}
+
+// Writer returns a writer that masks the secrets before writing to w.
+func (r *Redactor) Writer(w io.Writer) *RedactWriter {
+	return &RedactWriter{r: r, w: w}
+}
+
+// RedactWriter masks secrets in the output written to w. The output is held
+// back up to the end of each line so values split across writes are masked
+// too, Flush writes the rest.
+type RedactWriter struct {
+	r   *Redactor
+	w   io.Writer
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (rw *RedactWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.buf = append(rw.buf, p...)
+	n := bytes.LastIndexByte(rw.buf, '\n') + 1
+	if n == 0 && len(rw.buf) > maxRedactBuffer {
+		n = len(rw.buf)
+	}
+	if n == 0 {
+		return len(p), nil
+	}
+	if err := rw.write(n); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes the output that is held back because it doesn't end with a
+// newline.
+func (rw *RedactWriter) Flush() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if len(rw.buf) == 0 {
+		return nil
+	}
+	return rw.write(len(rw.buf))
+}
+
+func (rw *RedactWriter) write(n int) error {
+	s := rw.r.Mask(string(rw.buf[:n]))
+	rw.buf = append(rw.buf[:0], rw.buf[n:]...)
+	_, err := io.WriteString(rw.w, s)
+	return err
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// autoRuntime makes the plugin detect the runtime from the function source.
+const autoRuntime = "auto"
+
+// runtimeSettingValues returns the values accepted by the runtime settings.
+func runtimeSettingValues() []string {
+	return append([]string{autoRuntime}, validRuntimes...)
+}
+
+// version is a language version, minor is -1 for versions that only have a
+// major version (e.g. nodejs20) or if it's not specified.
+type version struct {
+	major int
+	minor int
+}
+
+func (v version) String() string {
+	if v.minor == -1 {
+		return strconv.Itoa(v.major)
+	}
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+// compare returns -1, 0 or 1, minor versions are only compared if both
+// versions have one.
+func (v version) compare(o version) int {
+	switch {
+	case v.major < o.major:
+		return -1
+	case v.major > o.major:
+		return 1
+	case v.minor == -1 || o.minor == -1 || v.minor == o.minor:
+		return 0
+	case v.minor < o.minor:
+		return -1
+	}
+	return 1
+}
+
+var versionRegex = regexp.MustCompile(`^v?(\d+)(?:\.(\d+|x|\*))?`)
+
+// parseVersion parses the major and minor version of versions like 3.11.4,
+// 18.x or v20, everything after the minor version is ignored.
+func parseVersion(s string) (version, bool) {
+	m := versionRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return version{}, false
+	}
+	v := version{minor: -1}
+	v.major, _ = strconv.Atoi(m[1])
+	if n, err := strconv.Atoi(m[2]); err == nil {
+		v.minor = n
+	}
+	return v, true
+}
+
+// runtimeVersion returns the language version of a runtime, e.g. 1.21 for
+// go121, 3.11 for python311 or 20 for nodejs20.
+func runtimeVersion(r string) (version, bool) {
+	family := runtimeFamily(r)
+	digits := strings.TrimPrefix(r, family)
+	if digits == "" {
+		return version{}, false
+	}
+	switch family {
+	case "go":
+		n, err := strconv.Atoi(strings.TrimPrefix(digits, "1"))
+		return version{major: 1, minor: n}, err == nil
+	case "python", "ruby", "php":
+		major, _ := strconv.Atoi(digits[:1])
+		minor, err := strconv.Atoi(digits[1:])
+		return version{major: major, minor: minor}, err == nil
+	}
+	n, err := strconv.Atoi(digits)
+	return version{major: n, minor: -1}, err == nil
+}
+
+var (
+	constraintRegex      = regexp.MustCompile(`^(>=|<=|>|<|===|==|=|!=|~=|~>|\^|~)?\s*(.+)$`)
+	constraintSpaceRegex = regexp.MustCompile(`([<>=!~^])\s+`)
+)
+
+// matchesConstraint checks v against a version constraint as used by
+// package.json, pyproject.toml, Gemfile and composer.json, e.g. ">=3.9,<3.12",
+// "^18 || ^20" or "~> 2.7".
+func matchesConstraint(v version, c string) bool {
+	for _, alt := range strings.Split(c, "||") {
+		if matchesAll(v, alt) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAll(v version, c string) bool {
+	// ">= 3.9" and ">=3.9" are both used
+	c = constraintSpaceRegex.ReplaceAllString(c, "$1")
+	parts := strings.FieldsFunc(c, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(parts) == 0 {
+		return false
+	}
+	for _, p := range parts {
+		if !matchesComparator(v, p) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesComparator(v version, c string) bool {
+	if c == "*" || c == "x" {
+		return true
+	}
+	m := constraintRegex.FindStringSubmatch(c)
+	if m == nil {
+		return false
+	}
+	cv, ok := parseVersion(m[2])
+	if !ok {
+		return false
+	}
+	cmp := v.compare(cv)
+	switch m[1] {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	case "^", "~=", "~>":
+		// same major version and not older
+		return v.major == cv.major && cmp >= 0
+	}
+	// "", "=", "==", "===" and "~" match the given parts of the version
+	return cmp == 0
+}
+
+// closestRuntime returns the oldest runtime of the family that provides at
+// least version v, newer versions of a language can build older code.
+func closestRuntime(family string, v version) (string, bool) {
+	res, best := "", version{}
+	for _, r := range deployableRuntimes() {
+		rv, ok := runtimeVersion(r)
+		if runtimeFamily(r) != family || !ok || rv.compare(v) < 0 {
+			continue
+		}
+		if res == "" || rv.compare(best) < 0 {
+			res, best = r, rv
+		}
+	}
+	return res, res != ""
+}
+
+// newestRuntime returns the newest runtime of the family that matches the
+// version constraint c.
+func newestRuntime(family string, c string) (string, bool) {
+	res, best := "", version{}
+	for _, r := range deployableRuntimes() {
+		rv, ok := runtimeVersion(r)
+		if runtimeFamily(r) != family || !ok || !matchesConstraint(rv, c) {
+			continue
+		}
+		if res == "" || rv.compare(best) > 0 {
+			res, best = r, rv
+		}
+	}
+	return res, res != ""
+}
+
+// runtimeDetector detects the runtime from a file (or glob pattern) in the
+// source directory.
+type runtimeDetector struct {
+	file   string
+	detect func(p string) (string, error)
+}
+
+// runtimeDetectors are tried in order, the first one that finds its file
+// decides the runtime.
+var runtimeDetectors = []runtimeDetector{
+	{"go.mod", detectGoRuntime},
+	{".python-version", detectPythonVersionRuntime},
+	{"pyproject.toml", detectPyprojectRuntime},
+	{"package.json", detectNodeRuntime},
+	{"*.csproj", detectDotnetRuntime},
+	{"Gemfile", detectRubyRuntime},
+	{"composer.json", detectPHPRuntime},
+	{"pom.xml", detectJavaRuntime},
+}
+
+// detectRuntime inspects the source in src and returns the closest
+// supported runtime and the file it was detected from.
+func detectRuntime(src string) (string, string, error) {
+	for _, d := range runtimeDetectors {
+		matches, _ := filepath.Glob(filepath.Join(src, d.file))
+		if len(matches) == 0 {
+			continue
+		}
+		r, err := d.detect(matches[0])
+		if err != nil {
+			return "", "", fmt.Errorf("can't detect runtime from %s: %s", filepath.Base(matches[0]), err)
+		}
+		return r, filepath.Base(matches[0]), nil
+	}
+	return "", "", fmt.Errorf("can't detect runtime of source %s, none of %s found", src, runtimeDetectorFiles())
+}
+
+func runtimeDetectorFiles() string {
+	files := []string{}
+	for _, d := range runtimeDetectors {
+		files = append(files, d.file)
+	}
+	return strings.Join(files, ", ")
+}
+
+func pinnedRuntime(family string, s string) (string, error) {
+	v, ok := parseVersion(s)
+	if !ok {
+		return "", fmt.Errorf("invalid version %q", s)
+	}
+	r, ok := closestRuntime(family, v)
+	if !ok {
+		return "", fmt.Errorf("no supported %s runtime for version %s", family, v)
+	}
+	return r, nil
+}
+
+func constrainedRuntime(family string, c string) (string, error) {
+	r, ok := newestRuntime(family, c)
+	if !ok {
+		return "", fmt.Errorf("no supported %s runtime matches %q", family, c)
+	}
+	return r, nil
+}
+
+func detectGoRuntime(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	m := goModGoRegex.FindSubmatch(b)
+	if m == nil {
+		return "", fmt.Errorf("missing go directive")
+	}
+	return pinnedRuntime("go", string(m[1])+"."+string(m[2]))
+}
+
+func detectPythonVersionRuntime(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return pinnedRuntime("python", strings.TrimSpace(string(b)))
+}
+
+var pyprojectRequiresPython = regexp.MustCompile(`(?m)^\s*requires-python\s*=\s*["']([^"']+)["']`)
+
+func detectPyprojectRuntime(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	m := pyprojectRequiresPython.FindSubmatch(b)
+	if m == nil {
+		return "", fmt.Errorf("missing requires-python")
+	}
+	return constrainedRuntime("python", string(m[1]))
+}
+
+func detectNodeRuntime(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	pkg := struct {
+		Engines map[string]string `json:"engines"`
+	}{}
+	if err := json.Unmarshal(b, &pkg); err != nil {
+		return "", err
+	}
+	c, ok := pkg.Engines["node"]
+	if !ok {
+		return "", fmt.Errorf("missing engines.node")
+	}
+	return constrainedRuntime("nodejs", c)
+}
+
+var csprojFramework = regexp.MustCompile(`<TargetFrameworks?>\s*(?:net|netcoreapp)(\d+)(?:\.\d+)?`)
+
+func detectDotnetRuntime(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	m := csprojFramework.FindSubmatch(b)
+	if m == nil {
+		return "", fmt.Errorf("missing TargetFramework")
+	}
+	return pinnedRuntime("dotnet", string(m[1]))
+}
+
+var gemfileRuby = regexp.MustCompile(`(?m)^\s*ruby\s+["']([^"']+)["']`)
+
+func detectRubyRuntime(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	m := gemfileRuby.FindSubmatch(b)
+	if m == nil {
+		return "", fmt.Errorf("missing ruby version")
+	}
+	if v := string(m[1]); strings.ContainsAny(v, "<>=~") {
+		return constrainedRuntime("ruby", v)
+	}
+	return pinnedRuntime("ruby", string(m[1]))
+}
+
+func detectPHPRuntime(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	composer := struct {
+		Require map[string]string `json:"require"`
+	}{}
+	if err := json.Unmarshal(b, &composer); err != nil {
+		return "", err
+	}
+	c, ok := composer.Require["php"]
+	if !ok {
+		return "", fmt.Errorf("missing require.php")
+	}
+	return constrainedRuntime("php", c)
+}
+
+var pomJavaVersion = regexp.MustCompile(`<(?:maven\.compiler\.release|maven\.compiler\.source|maven\.compiler\.target|java\.version)>\s*([\d.]+)\s*<`)
+
+func detectJavaRuntime(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	m := pomJavaVersion.FindSubmatch(b)
+	if m == nil {
+		return "", fmt.Errorf("missing java version")
+	}
+	// old versions are written as 1.8
+	return pinnedRuntime("java", strings.TrimPrefix(string(m[1]), "1."))
+}
+
+// resolveAutoRuntime detects the runtime of a function with runtime auto.
+func resolveAutoRuntime(dir string, f Function) (string, error) {
+	if isRemoteSource(f.Source) || isZipSource(f.Source) {
+		return "", fmt.Errorf("runtime auto needs a source directory, set the runtime of source %s", f.Source)
+	}
+	src := sourceDir(dir, f)
+	if fi, err := os.Stat(src); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("runtime auto needs a source directory, %s is not a directory", src)
+	}
+	r, file, err := detectRuntime(src)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Function %s: using runtime %s, detected from %s", f.Name, r, file)
+	return r, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRuntimeVersion(t *testing.T) {
+	for r, expected := range map[string]string{
+		"go121":     "1.21",
+		"go111":     "1.11",
+		"python39":  "3.9",
+		"python311": "3.11",
+		"nodejs20":  "20",
+		"java17":    "17",
+		"dotnet6":   "6",
+		"ruby30":    "3.0",
+		"php81":     "8.1",
+	} {
+		if v, ok := runtimeVersion(r); !ok || v.String() != expected {
+			t.Errorf("runtimeVersion(%s) got: %s   expected: %s", r, v, expected)
+		}
+	}
+}
+
+func TestMatchesConstraint(t *testing.T) {
+	for _, tst := range []struct {
+		v          string
+		constraint string
+		ok         bool
+	}{
+		{"18", ">=18", true},
+		{"16", ">=18", false},
+		{"20", ">= 18", true},
+		{"20", "18.x", false},
+		{"18", "18.x", true},
+		{"18", "^18.17.0", true},
+		{"20", "^18 || ^20", true},
+		{"3.11", ">=3.9,<3.12", true},
+		{"3.12", ">=3.9,<3.12", false},
+		{"3.10", "~=3.9", true},
+		{"3.8", "~=3.9", false},
+		{"2.7", "~> 2.7", true},
+		{"3.0", "~> 2.7", false},
+		{"8.1", "^8.0", true},
+		{"7.4", "^8.0", false},
+		{"3.11", "==3.11.*", true},
+		{"3.11", "!=3.11", false},
+		{"20", "*", true},
+	} {
+		v, _ := parseVersion(tst.v)
+		if res := matchesConstraint(v, tst.constraint); res != tst.ok {
+			t.Errorf("matchesConstraint(%s, %s) got: %t   expected: %t", tst.v, tst.constraint, res, tst.ok)
+		}
+	}
+}
+
+func TestDetectRuntime(t *testing.T) {
+	for _, tst := range []struct {
+		files    map[string]string
+		expected string
+		err      string
+	}{
+		{files: map[string]string{"go.mod": "module f\n\ngo 1.21\n"}, expected: "go121"},
+		{files: map[string]string{"go.mod": "module f\n\ngo 1.17\n"}, expected: "go118"},
+		{files: map[string]string{"go.mod": "module f\n\ngo 1.30\n"}, err: "no supported go runtime for version 1.30"},
+		{files: map[string]string{"go.mod": "module f\n"}, err: "can't detect runtime from go.mod: missing go directive"},
+		{files: map[string]string{".python-version": "3.10.4\n", "main.py": ""}, expected: "python310"},
+		{files: map[string]string{"pyproject.toml": "[project]\nrequires-python = \">=3.8,<3.11\"\n"}, expected: "python310"},
+		{files: map[string]string{"package.json": `{"engines": {"node": ">=16 <22"}}`}, expected: "nodejs20"},
+		{files: map[string]string{"package.json": `{"engines": {"node": "18.x"}}`}, expected: "nodejs18"},
+		{files: map[string]string{"package.json": `{"name": "f"}`}, err: "missing engines.node"},
+		{files: map[string]string{"package.json": `{"engines": {"node": "^4"}}`}, err: `no supported nodejs runtime matches "^4"`},
+		{files: map[string]string{"Function.csproj": "<Project><PropertyGroup><TargetFramework>net6.0</TargetFramework></PropertyGroup></Project>"}, expected: "dotnet6"},
+		{files: map[string]string{"Function.csproj": "<TargetFramework>netcoreapp3.1</TargetFramework>"}, expected: "dotnet3"},
+		{files: map[string]string{"Gemfile": "source 'https://rubygems.org'\nruby '2.7.4'\n"}, expected: "ruby27"},
+		{files: map[string]string{"Gemfile": "ruby \"~> 2.6\"\n"}, expected: "ruby27"},
+		{files: map[string]string{"composer.json": `{"require": {"php": ">=7.4"}}`}, expected: "php83"},
+		{files: map[string]string{"pom.xml": "<properties><maven.compiler.release>17</maven.compiler.release></properties>"}, expected: "java17"},
+		{files: map[string]string{"pom.xml": "<properties><java.version>1.8</java.version></properties>"}, expected: "java11"},
+		{files: map[string]string{"main.py": ""}, err: "none of go.mod, .python-version"},
+	} {
+		dir := t.TempDir()
+		writeFiles(t, dir, tst.files)
+
+		r, _, err := detectRuntime(dir)
+		if tst.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.err) {
+				t.Errorf("detectRuntime(%v) expected error %q, got: %v", tst.files, tst.err, err)
+			}
+			continue
+		}
+		if err != nil || r != tst.expected {
+			t.Errorf("detectRuntime(%v) got: %s   expected: %s   err: %v", tst.files, r, tst.expected, err)
+		}
+	}
+}
+
+func TestParseConfigAutoRuntime(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go/go.mod":          "module f\n\ngo 1.20\n",
+		"node/package.json":  `{"engines": {"node": "18"}}`,
+		"unknown/README.txt": "",
+	})
+
+	os.Clearenv()
+	os.Setenv("PLUGIN_ACTION", "validate")
+	os.Setenv("DRONE_WORKSPACE", dir)
+	os.Setenv("PLUGIN_RUNTIME", "auto")
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"GoFunc":[{"trigger":"http","source":"go"}]},{"NodeFunc":[{"trigger":"http","source":"node","runtime":"auto"}]},{"Other":[{"trigger":"http","source":"unknown"}]},{"Zip":[{"trigger":"http","source":"dist/f.zip"}]}]`)
+
+	_, err := parseConfig()
+	if err == nil {
+		t.Fatalf("expected error for function Other")
+	}
+	for _, s := range []string{"function Other: can't detect runtime of source", "function Zip: runtime auto needs a source directory"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in error, got: %s", s, err)
+		}
+	}
+
+	functions, err := parseFunctions(`[{"GoFunc":[{"trigger":"http","source":"go"}]},{"NodeFunc":[{"trigger":"http","source":"node"}]}]`, "auto", nil)
+	if err != nil {
+		t.Fatalf("parseFunctions() err: %s", err)
+	}
+	for _, f := range functions {
+		r, err := resolveAutoRuntime(dir, f)
+		if err != nil {
+			t.Errorf("resolveAutoRuntime(%s) err: %s", f.Name, err)
+		}
+		if expected := map[string]string{"GoFunc": "go120", "NodeFunc": "nodejs18"}[f.Name]; r != expected {
+			t.Errorf("resolveAutoRuntime(%s) got: %s   expected: %s", f.Name, r, expected)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	maxConcurrency = 1000
+	maxCPU         = 8
+)
+
+// fractional cpus of gen2 functions, more than one cpu has to be one of the
+// whole numbers
+var (
+	validFractionalCPUs = []float64{0.083, 0.167, 0.333, 0.583}
+	validWholeCPUs      = []float64{1, 2, 4, 6, 8}
+)
+
+func containsFloat(l []float64, f float64) bool {
+	for _, x := range l {
+		if x == f {
+			return true
+		}
+	}
+	return false
+}
+
+func formatCPU(cpu float64) string {
+	return strconv.FormatFloat(cpu, 'f', -1, 64)
+}
+
+// validateScaling checks the instance and concurrency settings of f.
+func validateScaling(f Function) ConfigErrors {
+	errs := ConfigErrors{}
+
+	if f.MinInstances != nil && f.ClearMinInstances {
+		errs.Add("min_instances and clear_min_instances can't be used together")
+	}
+	if f.MaxInstances != nil && f.ClearMaxInstances {
+		errs.Add("max_instances and clear_max_instances can't be used together")
+	}
+	if f.MinInstances != nil && *f.MinInstances < 0 {
+		errs.Add("min_instances must not be negative, got %d", *f.MinInstances)
+	}
+	if f.MaxInstances != nil && *f.MaxInstances < 1 {
+		errs.Add("max_instances must be at least 1, got %d", *f.MaxInstances)
+	}
+	if f.MinInstances != nil && f.MaxInstances != nil && *f.MinInstances > *f.MaxInstances {
+		errs.Add("min_instances %d is larger than max_instances %d", *f.MinInstances, *f.MaxInstances)
+	}
+
+	if f.Concurrency != nil {
+		switch {
+		case !f.Gen2:
+			errs.Add("concurrency is only supported by gen2 functions")
+		case *f.Concurrency < 1 || *f.Concurrency > maxConcurrency:
+			errs.Add("concurrency must be between 1 and %d, got %d", maxConcurrency, *f.Concurrency)
+		case *f.Concurrency > 1 && f.CPU != nil && *f.CPU < 1:
+			errs.Add("concurrency %d requires at least 1 cpu, got %s", *f.Concurrency, formatCPU(*f.CPU))
+		}
+	}
+
+	if f.CPU != nil {
+		switch cpu := *f.CPU; {
+		case !f.Gen2:
+			errs.Add("cpu is only supported by gen2 functions")
+		case cpu <= 0 || cpu > maxCPU:
+			errs.Add("cpu must be between 0.083 and %d, got %s", maxCPU, formatCPU(cpu))
+		case cpu < 1 && !containsFloat(validFractionalCPUs, cpu):
+			errs.Add("invalid cpu %s, fractional cpus must be one of: 0.083, 0.167, 0.333, 0.583", formatCPU(cpu))
+		case cpu >= 1 && !containsFloat(validWholeCPUs, cpu):
+			errs.Add("invalid cpu %s, must be one of: 1, 2, 4, 6, 8", formatCPU(cpu))
+		}
+	}
+
+	return errs
+}
+
+// scalingWarnings returns the warnings for scaling settings that gcloud
+// accepts but that likely don't work as intended.
+func scalingWarnings(f Function) []string {
+	res := []string{}
+	if f.Gen2 && f.Concurrency != nil && *f.Concurrency > 1 && f.CPU == nil {
+		res = append(res, fmt.Sprintf("concurrency %d needs at least 1 cpu, set cpu unless the memory is 2GB or more", *f.Concurrency))
+	}
+	return res
+}
+
+// scalingArgs returns the gcloud arguments for the scaling settings of f.
+func scalingArgs(f Function) []string {
+	args := []string{}
+	if f.MinInstances != nil {
+		args = append(args, "--min-instances", strconv.Itoa(*f.MinInstances))
+	}
+	if f.ClearMinInstances {
+		args = append(args, "--clear-min-instances")
+	}
+	if f.MaxInstances != nil {
+		args = append(args, "--max-instances", strconv.Itoa(*f.MaxInstances))
+	}
+	if f.ClearMaxInstances {
+		args = append(args, "--clear-max-instances")
+	}
+	if f.Concurrency != nil {
+		args = append(args, "--concurrency", strconv.Itoa(*f.Concurrency))
+	}
+	if f.CPU != nil {
+		args = append(args, "--cpu", formatCPU(*f.CPU))
+	}
+	return args
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestValidateScaling(t *testing.T) {
+	for _, tst := range []struct {
+		f   Function
+		err string
+	}{
+		{f: Function{MinInstances: intPtr(0), MaxInstances: intPtr(10)}},
+		{f: Function{Gen2: true, Concurrency: intPtr(80), CPU: floatPtr(1)}},
+		{f: Function{Gen2: true, CPU: floatPtr(0.583)}},
+		{f: Function{ClearMinInstances: true, ClearMaxInstances: true}},
+		{f: Function{MinInstances: intPtr(1), ClearMinInstances: true}, err: "min_instances and clear_min_instances can't be used together"},
+		{f: Function{MaxInstances: intPtr(1), ClearMaxInstances: true}, err: "max_instances and clear_max_instances can't be used together"},
+		{f: Function{MinInstances: intPtr(-1)}, err: "min_instances must not be negative"},
+		{f: Function{MaxInstances: intPtr(0)}, err: "max_instances must be at least 1"},
+		{f: Function{MinInstances: intPtr(5), MaxInstances: intPtr(2)}, err: "min_instances 5 is larger than max_instances 2"},
+		{f: Function{Concurrency: intPtr(10)}, err: "concurrency is only supported by gen2 functions"},
+		{f: Function{Gen2: true, Concurrency: intPtr(5000)}, err: "concurrency must be between 1 and 1000"},
+		{f: Function{Gen2: true, Concurrency: intPtr(10), CPU: floatPtr(0.583)}, err: "concurrency 10 requires at least 1 cpu, got 0.583"},
+		{f: Function{CPU: floatPtr(1)}, err: "cpu is only supported by gen2 functions"},
+		{f: Function{Gen2: true, CPU: floatPtr(16)}, err: "cpu must be between 0.083 and 8"},
+		{f: Function{Gen2: true, CPU: floatPtr(0.5)}, err: "invalid cpu 0.5, fractional cpus must be one of"},
+		{f: Function{Gen2: true, CPU: floatPtr(3)}, err: "invalid cpu 3, must be one of: 1, 2, 4, 6, 8"},
+	} {
+		errs := validateScaling(tst.f)
+		if tst.err == "" {
+			if len(errs) > 0 {
+				t.Errorf("validateScaling(%#v) err: %s", tst.f, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), tst.err) {
+			t.Errorf("validateScaling(%#v) expected error %q, got: %v", tst.f, tst.err, errs)
+		}
+	}
+
+	if w := scalingWarnings(Function{Gen2: true, Concurrency: intPtr(10)}); len(w) != 1 {
+		t.Errorf("expected cpu warning, got: %v", w)
+	}
+}
+
+func TestScalingArgs(t *testing.T) {
+	functions, err := parseFunctions(`[{"Scaled":[{"trigger":"http","gen2":true,"runtime":"go121","min_instances":0,"max_instances":20,"concurrency":80,"cpu":1}]},{"Cleared":[{"trigger":"http","runtime":"go121","clear_min_instances":true,"clear_max_instances":true}]}]`, "go121", nil)
+	if err != nil {
+		t.Fatalf("parseFunctions() err: %s", err)
+	}
+
+	expected := map[string][]string{
+		"Scaled":  {"--min-instances", "0", "--max-instances", "20", "--concurrency", "80", "--cpu", "1"},
+		"Cleared": {"--clear-min-instances", "--clear-max-instances"},
+	}
+	for _, f := range functions {
+		if args := scalingArgs(f); !reflect.DeepEqual(args, expected[f.Name]) {
+			t.Errorf("scalingArgs(%s) got: %#v   expected: %#v", f.Name, args, expected[f.Name])
+		}
+	}
+
+	if args := scalingArgs(Function{Gen2: true, CPU: floatPtr(0.583)}); !reflect.DeepEqual(args, []string{"--cpu", "0.583"}) {
+		t.Errorf("scalingArgs() got: %#v", args)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Schema is the subset of JSON Schema (draft-07) that is needed to describe
+// the functions setting.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Examples             []string           `json:"examples,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// descriptions of the function settings as shown in the schema, keyed by
+// the name of the setting
+var functionSettingDescriptions = map[string]string{
+	"name":                        "Name of the function, taken from the key of the entry.",
+	"trigger":                     "Type of the trigger that invokes the function.",
+	"trigger_event":               "Event type for the event trigger.",
+	"trigger_resource":            "Bucket, topic or resource for non-http triggers.",
+	"security_level":              "Security level of the http trigger (gen1 only).",
+	"event_filters":               "Eventarc event filters as attribute: value, the type filter is required.",
+	"event_filters_path_pattern":  "Eventarc event filters whose values are path patterns, e.g. documents/users/{id}.",
+	"trigger_location":            "Location of the Eventarc trigger.",
+	"trigger_service_account":     "Service account used by the Eventarc trigger to invoke the function.",
+	"allow_unauthenticated":       "Allow unauthenticated invocations of the function.",
+	"gen2":                        "Deploy as a 2nd generation function.",
+	"entrypoint":                  "Name of the function in the source code, defaults to the function name.",
+	"memory":                      "Memory limit of the function, e.g. 256MB.",
+	"region":                      "Region to deploy the function to.",
+	"retry":                       "Retry failed invocations of event driven functions.",
+	"runtime":                     "Runtime of the function, defaults to the runtime setting of the step. Use auto to detect it from the source.",
+	"source":                      "Location of the source code of the function: a directory or zip file in the workspace, a gs:// url of a zip file or a Cloud Source Repositories url.",
+	"timeout":                     "Timeout of the function, e.g. 60s.",
+	"serviceaccount":              "Service account the function runs as.",
+	"vpcconnector":                "VPC connector the function uses.",
+	"environment_delimiter":       "Delimiter used to separate environment variables passed to gcloud.",
+	"environment":                 "Environment variables of the function.",
+	"secrets":                     "Secret Manager secrets mounted as files or exposed as environment variables.",
+	"env_mode":                    "replace sets exactly the given environment variables, merge only adds or updates them and keeps the others.",
+	"remove_env":                  "Environment variables removed from the function, requires env_mode merge.",
+	"secrets_mode":                "replace sets exactly the given secrets, merge only adds or updates them and keeps the others.",
+	"remove_secrets":              "Secrets removed from the function, requires secrets_mode merge.",
+	"env_vars_file":               "YAML file with environment variables of the function, merged with the other variables.",
+	"data":                        "Data passed to the function when calling it.",
+	"ingress_settings":            "Ingress settings of the function.",
+	"egress_settings":             "Egress settings of the function.",
+	"min_instances":               "Minimum number of instances kept running, 0 removes the minimum.",
+	"max_instances":               "Maximum number of instances of the function.",
+	"clear_min_instances":         "Remove the minimum number of instances set by an earlier deploy.",
+	"clear_max_instances":         "Remove the maximum number of instances set by an earlier deploy.",
+	"concurrency":                 "Number of concurrent requests per instance (gen2 only).",
+	"cpu":                         "Number of cpus per instance, e.g. 0.583 or 2 (gen2 only).",
+	"labels":                      "Labels of the function, added to the provenance labels of the build.",
+	"clear_labels":                "Remove all labels set by earlier deploys before adding the labels.",
+	"build":                       "Configuration of the build of the function.",
+	"env_secrets":                 "Names of the env_secret_ variables the function receives, all of them if not set.",
+	"impersonate_service_account": "Service account the gcloud commands of the function run as, overrides the impersonate_service_account setting of the step.",
+}
+
+// functionSettingEnums returns the allowed values of the enum-like settings.
+func functionSettingEnums() map[string][]string {
+	return map[string][]string{
+		"trigger":          validTriggerTypes,
+		"security_level":   validSecureTypes,
+		"ingress_settings": validIngressSettings,
+		"egress_settings":  validEgressSettings,
+		"env_mode":         validUpdateModes,
+		"secrets_mode":     validUpdateModes,
+	}
+}
+
+// functionSchema returns the schema of the settings of a single function.
+func functionSchema() *Schema {
+	s := schemaForType(reflect.TypeOf(Function{}))
+	enums := functionSettingEnums()
+	for name, p := range s.Properties {
+		p.Description = functionSettingDescriptions[name]
+		if e, ok := enums[name]; ok {
+			p.Enum = e
+		}
+	}
+	for name, p := range s.Properties["build"].Properties {
+		p.Description = buildSettingDescriptions[name]
+	}
+	s.Properties["build"].Properties["docker_registry"].Enum = validDockerRegistries
+	// runtimes newer than the catalog are accepted, see validateRuntime
+	s.Properties["runtime"].Examples = runtimeSettingValues()
+	return s
+}
+
+// functionsSchema returns the schema of the functions setting: either a
+// list of function names or a list of functions with their settings.
+func functionsSchema() *Schema {
+	return &Schema{
+		Schema:      "http://json-schema.org/draft-07/schema#",
+		Title:       "drone-gcf functions",
+		Description: "The functions setting of the drone-gcf plugin.",
+		OneOf: []*Schema{
+			{
+				Description: "Functions with their settings.",
+				Type:        "array",
+				Items: &Schema{
+					Type: "object",
+					AdditionalProperties: &Schema{
+						Type:  "array",
+						Items: &Schema{Ref: "#/definitions/function"},
+					},
+				},
+			},
+			{
+				Description: "Names of the functions, e.g. for the delete action.",
+				Type:        "array",
+				Items:       &Schema{Type: "string"},
+			},
+			{
+				Description: "Comma separated list of function names.",
+				Type:        "string",
+			},
+		},
+		Definitions: map[string]*Schema{"function": functionSchema()},
+	}
+}
+
+// writeSchema writes the indented json schema of the functions setting to w.
+func writeSchema(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(functionsSchema())
+}
+
+func schemaForType(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaForType(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: schemaForType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaForType(t.Elem())}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+				continue
+			}
+			s.Properties[jsonFieldName(f)] = schemaForType(f.Type)
+		}
+		return s
+	}
+	panic(fmt.Sprintf("no schema for type %s", t))
+}
+
+// jsonFieldName returns the name under which a struct field appears in the
+// functions setting.
+func jsonFieldName(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" {
+		return tag
+	}
+	return strings.ToLower(f.Name)
+}
+
+// Validate checks v, as decoded by encoding/json with UseNumber(), against
+// the schema and returns all violations.
+func (s *Schema) Validate(v interface{}) ConfigErrors {
+	errs := ConfigErrors{}
+	s.validate(v, "", &errs)
+	return errs
+}
+
+func (s *Schema) validate(v interface{}, path string, errs *ConfigErrors) {
+	if s.Type != "" && !matchesSchemaType(s.Type, v) {
+		errs.Add("%s must be %s, got %s", settingPath(path), schemaTypeName(s.Type), jsonTypeName(v))
+		return
+	}
+
+	switch v := v.(type) {
+	case string:
+		if len(s.Enum) > 0 && v != "" && !containsString(s.Enum, v) {
+			if sg := suggest(v, s.Enum); sg != "" {
+				errs.Add("invalid value %q for %s, did you mean %q?", v, settingPath(path), sg)
+			} else {
+				errs.Add("invalid value %q for %s, must be one of: %s", v, settingPath(path), strings.Join(s.Enum, ", "))
+			}
+		}
+
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if p := s.property(k); p != nil {
+				p.validate(v[k], joinFieldPath(path, k), errs)
+				continue
+			}
+
+			switch ap := s.AdditionalProperties.(type) {
+			case *Schema:
+				ap.validate(v[k], joinFieldPath(path, k), errs)
+			case bool:
+				if ap {
+					continue
+				}
+				known := make([]string, 0, len(s.Properties))
+				for p := range s.Properties {
+					known = append(known, p)
+				}
+				if sg := suggest(k, known); sg != "" {
+					errs.Add("unknown setting %q, did you mean %q?", joinFieldPath(path, k), sg)
+				} else {
+					errs.Add("unknown setting %q", joinFieldPath(path, k))
+				}
+			}
+		}
+	}
+}
+
+// property looks up the schema of a property, case-insensitive like
+// encoding/json does when decoding.
+func (s *Schema) property(k string) *Schema {
+	if p, ok := s.Properties[k]; ok {
+		return p
+	}
+	for name, p := range s.Properties {
+		if strings.EqualFold(name, k) {
+			return p
+		}
+	}
+	return nil
+}
+
+func matchesSchemaType(t string, v interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		return t == "string"
+	case bool:
+		return t == "boolean"
+	case json.Number:
+		if t == "integer" {
+			_, err := v.Int64()
+			return err == nil
+		}
+		return t == "number"
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	case nil:
+		return true
+	}
+	return false
+}
+
+func schemaTypeName(t string) string {
+	switch t {
+	case "array", "object", "integer":
+		return "an " + t
+	}
+	return "a " + t
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func settingPath(path string) string {
+	if path == "" {
+		return "value"
+	}
+	return fmt.Sprintf("setting %q", path)
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFunctionSchemaCoversAllSettings(t *testing.T) {
+	s := functionSchema()
+
+	ft := reflect.TypeOf(Function{})
+	for i := 0; i < ft.NumField(); i++ {
+		if ft.Field(i).PkgPath != "" {
+			// unexported fields aren't settings
+			continue
+		}
+		name := jsonFieldName(ft.Field(i))
+		p, ok := s.Properties[name]
+		if !ok {
+			t.Errorf("missing property in schema: %s", name)
+			continue
+		}
+		if p.Description == "" {
+			t.Errorf("missing description for setting: %s", name)
+		}
+	}
+
+	for name, values := range functionSettingEnums() {
+		p, ok := s.Properties[name]
+		if !ok {
+			t.Errorf("enum for unknown setting: %s", name)
+			continue
+		}
+		if !reflect.DeepEqual(p.Enum, values) {
+			t.Errorf("enum of %s doesn't match, got: %#v", name, p.Enum)
+		}
+	}
+
+	if s.AdditionalProperties != false {
+		t.Errorf("function schema should not allow additional properties")
+	}
+	if s.Properties["gen2"].Type != "boolean" || s.Properties["environment"].Type != "array" || s.Properties["secrets"].Type != "object" {
+		t.Errorf("unexpected property types: %#v", s.Properties)
+	}
+}
+
+func TestWriteSchema(t *testing.T) {
+	b := &bytes.Buffer{}
+	if err := writeSchema(b); err != nil {
+		t.Fatalf("writeSchema() err: %s", err)
+	}
+
+	res := map[string]interface{}{}
+	if err := json.Unmarshal(b.Bytes(), &res); err != nil {
+		t.Fatalf("schema is not valid json: %s", err)
+	}
+	if res["$schema"] != "http://json-schema.org/draft-07/schema#" {
+		t.Errorf("missing $schema, got: %#v", res["$schema"])
+	}
+	for _, s := range []string{`"runtime"`, `"go121"`, `"internal-and-gclb"`, `"secure-always"`, `"#/definitions/function"`} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected schema to contain %s", s)
+		}
+	}
+}
+
+func TestFunctionsSchemaShapes(t *testing.T) {
+	// one example for every shape of the functions setting in the schema
+	examples := []struct {
+		in            string
+		expectedNames []string
+	}{
+		{in: `[{"Hello":[{"trigger":"http"}]},{"World":[{"trigger":"topic","trigger_resource":"t"}]}]`, expectedNames: []string{"Hello", "World"}},
+		{in: `["Hello", "World"]`, expectedNames: []string{"Hello", "World"}},
+		{in: `Hello,World`, expectedNames: []string{"Hello", "World"}},
+	}
+
+	shapes := functionsSchema().OneOf
+	if len(shapes) != len(examples) {
+		t.Fatalf("expected %d shapes of the functions setting, got: %d", len(examples), len(shapes))
+	}
+	for i, shape := range shapes {
+		tst := examples[i]
+		var v interface{} = tst.in
+		if shape.Type != "string" {
+			dec := json.NewDecoder(strings.NewReader(tst.in))
+			dec.UseNumber()
+			if err := dec.Decode(&v); err != nil {
+				t.Fatalf("invalid test input %s: %s", tst.in, err)
+			}
+		}
+		if errs := shape.Validate(v); len(errs) > 0 {
+			t.Errorf("%s doesn't match the schema %q: %s", tst.in, shape.Description, errs)
+		}
+
+		fs, err := parseFunctions(tst.in, "", nil)
+		if err != nil {
+			t.Errorf("parseFunctions(%s) err: %s", tst.in, err)
+			continue
+		}
+		names := []string{}
+		for _, f := range fs {
+			names = append(names, f.Name)
+		}
+		if !reflect.DeepEqual(names, tst.expectedNames) {
+			t.Errorf("parseFunctions(%s) got: %v   expected: %v", tst.in, names, tst.expectedNames)
+		}
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	for _, tst := range []struct {
+		in             string
+		expectedErrors []string
+	}{
+		{in: `{"trigger":"http","memory":"512MB","gen2":true,"environment":[{"K":"V"}],"secrets":{"K":"s:1"}}`},
+		{in: `{"Trigger":"http","Data":"{}"}`},
+		{in: `{"trigger":"htp"}`, expectedErrors: []string{`invalid value "htp" for setting "trigger", did you mean "http"?`}},
+		{in: `{"ingress_settings":"nope"}`, expectedErrors: []string{`invalid value "nope" for setting "ingress_settings", must be one of: all, internal-only, internal-and-gclb`}},
+		{in: `{"memory":512}`, expectedErrors: []string{`setting "memory" must be a string, got number`}},
+		{in: `{"environment":[{"K":1}]}`, expectedErrors: []string{`setting "environment[0].K" must be a string, got number`}},
+		{in: `{"secrets":["a"]}`, expectedErrors: []string{`setting "secrets" must be an object, got array`}},
+		{in: `{"retrys":true,"timout":"1s"}`, expectedErrors: []string{`unknown setting "retrys", did you mean "retry"?`, `unknown setting "timout", did you mean "timeout"?`}},
+	} {
+		var v interface{}
+		dec := json.NewDecoder(strings.NewReader(tst.in))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("invalid test input %s: %s", tst.in, err)
+		}
+
+		errs := functionSchema().Validate(v)
+		if len(errs) != len(tst.expectedErrors) {
+			t.Errorf("Validate(%s) expected %d errors, got: %s", tst.in, len(tst.expectedErrors), errs)
+			continue
+		}
+		for i, e := range tst.expectedErrors {
+			if errs[i].Error() != e {
+				t.Errorf("Validate(%s) expected error %q, got: %q", tst.in, e, errs[i])
+			}
+		}
+	}
+}
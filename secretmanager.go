@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultSecretManagerEndpoint = "https://secretmanager.googleapis.com"
+
+// secretManagerEndpoint returns the url of the Secret Manager API, it can be
+// pointed to a fake via SECRET_MANAGER_EMULATOR_HOST.
+func secretManagerEndpoint() string {
+	host := os.Getenv("SECRET_MANAGER_EMULATOR_HOST")
+	if host == "" {
+		return defaultSecretManagerEndpoint
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return strings.TrimRight(host, "/")
+}
+
+// SecretManagerClient is a minimal client for the Secret Manager REST API.
+type SecretManagerClient struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func NewSecretManagerClient(endpoint string, token string) *SecretManagerClient {
+	return &SecretManagerClient{
+		endpoint: endpoint,
+		token:    token,
+		client:   &http.Client{Timeout: time.Minute},
+	}
+}
+
+func (c *SecretManagerClient) do(method string, name string, body io.Reader, res interface{}) (int, error) {
+	req, err := http.NewRequest(method, c.endpoint+"/v1/"+name, body)
+	if err != nil {
+		return 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, secretManagerError(resp, b, name)
+	}
+	if res != nil {
+		if err := json.Unmarshal(b, res); err != nil {
+			return resp.StatusCode, fmt.Errorf("%s: invalid response: %s", name, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func secretManagerError(resp *http.Response, b []byte, name string) error {
+	msg := strings.TrimSpace(string(b))
+	res := struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	if json.Unmarshal(b, &res) == nil && res.Error.Message != "" {
+		msg = res.Error.Message
+	}
+	return fmt.Errorf("%s: %s: %s", name, resp.Status, msg)
+}
+
+// SecretVersion is the state of a version of a secret, e.g. ENABLED.
+type SecretVersion struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// GetVersion returns the version with the resource name
+// projects/P/secrets/S/versions/V, nil if it doesn't exist.
+func (c *SecretManagerClient) GetVersion(name string) (*SecretVersion, error) {
+	v := &SecretVersion{}
+	status, err := c.do(http.MethodGet, name, nil, v)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// verifySecrets checks that the secret versions used by the functions exist
+// and are enabled, as seen with the client of each function from clients.
+func verifySecrets(cfg *Config, clients func(f Function) (*SecretManagerClient, error)) error {
+	errs := ConfigErrors{}
+	checked := map[string]error{}
+	for _, f := range cfg.Functions {
+		if len(f.Secrets) == 0 {
+			continue
+		}
+		client, err := clients(f)
+		if err != nil {
+			return err
+		}
+		for _, k := range sortedKeys(f.Secrets) {
+			ref, err := parseSecretRef(f.Secrets[k])
+			if err != nil {
+				errs.Add("function %s: secret %s: %s", f.Name, k, err)
+				continue
+			}
+			// the accounts of the functions might not have the same access
+			name := ref.Name(cfg.Project)
+			key := functionImpersonation(cfg, f) + " " + name
+			if _, ok := checked[key]; !ok {
+				checked[key] = verifySecretVersion(client, name)
+			}
+			if err := checked[key]; err != nil {
+				errs.Add("function %s: secret %s: %s", f.Name, k, err)
+			}
+		}
+	}
+	if len(checked) > 0 && len(errs) == 0 {
+		log.Printf("Verified %d secret version(s)", len(checked))
+	}
+	return errs.Err()
+}
+
+func verifySecretVersion(client *SecretManagerClient, name string) error {
+	v, err := client.GetVersion(name)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return fmt.Errorf("%s doesn't exist", name)
+	}
+	if v.State != "" && v.State != "ENABLED" {
+		return fmt.Errorf("%s is %s", name, strings.ToLower(v.State))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSecretManager implements the parts of the Secret Manager API that
+// are used by SecretManagerClient. States of versions are keyed by resource
+// name, the values and annotations of secrets by the name of the secret.
+type fakeSecretManager struct {
+	sync.Mutex
+	versions    map[string]string
+	secrets     map[string][][]byte
+	annotations map[string]map[string]string
+	requests    int
+	added       int
+}
+
+func newFakeSecretManager(t *testing.T) (*fakeSecretManager, *httptest.Server) {
+	fsm := &fakeSecretManager{versions: map[string]string{}, secrets: map[string][][]byte{}, annotations: map[string]map[string]string{}}
+	srv := httptest.NewServer(fsm)
+	t.Cleanup(srv.Close)
+	return fsm, srv
+}
+
+// secretManagerClients returns c for every function.
+func secretManagerClients(c *SecretManagerClient) func(f Function) (*SecretManagerClient, error) {
+	return func(f Function) (*SecretManagerClient, error) { return c, nil }
+}
+
+func (fsm *fakeSecretManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fsm.Lock()
+	defer fsm.Unlock()
+	fsm.requests++
+
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		http.Error(w, `{"error": {"message": "Invalid Credentials"}}`, http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case r.Method == http.MethodGet && strings.Contains(name, "/versions/"):
+		state, ok := fsm.versions[name]
+		if !ok {
+			http.Error(w, `{"error": {"message": "Secret Version not found"}}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"name": "` + name + `", "state": "` + state + `"}`))
+
+	case r.Method == http.MethodGet:
+		if _, ok := fsm.secrets[name]; !ok {
+			http.Error(w, `{"error": {"message": "Secret not found"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(Secret{Name: name, Annotations: fsm.annotations[name]})
+
+	case r.Method == http.MethodPatch && r.URL.Query().Get("updateMask") == "annotations":
+		if _, ok := fsm.secrets[name]; !ok {
+			http.Error(w, `{"error": {"message": "Secret not found"}}`, http.StatusNotFound)
+			return
+		}
+		req := Secret{}
+		json.NewDecoder(r.Body).Decode(&req)
+		fsm.annotations[name] = req.Annotations
+		json.NewEncoder(w).Encode(Secret{Name: name, Annotations: req.Annotations})
+
+	case r.Method == http.MethodPost && strings.HasSuffix(name, "/secrets"):
+		secret := name + "/" + r.URL.Query().Get("secretId")
+		if _, ok := fsm.secrets[secret]; ok {
+			http.Error(w, `{"error": {"message": "Secret already exists"}}`, http.StatusConflict)
+			return
+		}
+		fsm.secrets[secret] = [][]byte{}
+		w.Write([]byte(`{"name": "` + secret + `"}`))
+
+	case r.Method == http.MethodPost && strings.HasSuffix(name, ":addVersion"):
+		secret := strings.TrimSuffix(name, ":addVersion")
+		if _, ok := fsm.secrets[secret]; !ok {
+			http.Error(w, `{"error": {"message": "Secret not found"}}`, http.StatusNotFound)
+			return
+		}
+		req := struct {
+			Payload struct {
+				Data string `json:"data"`
+			} `json:"payload"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		data, _ := base64.StdEncoding.DecodeString(req.Payload.Data)
+		fsm.secrets[secret] = append(fsm.secrets[secret], data)
+		fsm.added++
+		version := fmt.Sprintf("%s/versions/%d", secret, len(fsm.secrets[secret]))
+		fsm.versions[version] = "ENABLED"
+		fmt.Fprintf(w, `{"name": "%s", "state": "ENABLED"}`, version)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestSecretManagerEndpoint(t *testing.T) {
+	os.Clearenv()
+	if e := secretManagerEndpoint(); e != defaultSecretManagerEndpoint {
+		t.Errorf("got: %s", e)
+	}
+	os.Setenv("SECRET_MANAGER_EMULATOR_HOST", "localhost:8085/")
+	if e := secretManagerEndpoint(); e != "http://localhost:8085" {
+		t.Errorf("got: %s", e)
+	}
+	os.Setenv("SECRET_MANAGER_EMULATOR_HOST", "https://secrets.example.com")
+	if e := secretManagerEndpoint(); e != "https://secrets.example.com" {
+		t.Errorf("got: %s", e)
+	}
+}
+
+func TestVerifySecrets(t *testing.T) {
+	fsm, srv := newFakeSecretManager(t)
+	fsm.versions["projects/my-project/secrets/api-key/versions/1"] = "ENABLED"
+	fsm.versions["projects/my-project/secrets/cert/versions/latest"] = "ENABLED"
+	fsm.versions["projects/other-project/secrets/db/versions/2"] = "DISABLED"
+
+	client := NewSecretManagerClient(srv.URL, "test-token")
+	cfg := &Config{Project: "my-project", Functions: Functions{
+		{Name: "A", Secrets: map[string]string{"API_KEY": "api-key:1", "/etc/certs": "cert:latest"}},
+		{Name: "B", Secrets: map[string]string{"API_KEY": "api-key:1"}},
+	}}
+	if err := verifySecrets(cfg, secretManagerClients(client)); err != nil {
+		t.Fatalf("verifySecrets() err: %s", err)
+	}
+	if fsm.requests != 2 {
+		t.Errorf("expected every version to be checked once, got %d requests", fsm.requests)
+	}
+
+	cfg.Functions = Functions{
+		{Name: "A", Secrets: map[string]string{"API_KEY": "api-key:2", "DB": "projects/other-project/secrets/db:2"}},
+	}
+	err := verifySecrets(cfg, secretManagerClients(client))
+	for _, e := range []string{
+		"function A: secret API_KEY: projects/my-project/secrets/api-key/versions/2 doesn't exist",
+		"function A: secret DB: projects/other-project/secrets/db/versions/2 is disabled",
+	} {
+		if err == nil || !strings.Contains(err.Error(), e) {
+			t.Errorf("expected %q, got: %v", e, err)
+		}
+	}
+
+	err = verifySecrets(cfg, secretManagerClients(NewSecretManagerClient(srv.URL, "wrong-token")))
+	if err == nil || !strings.Contains(err.Error(), "401 Unauthorized: Invalid Credentials") {
+		t.Errorf("expected an auth error, got: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const latestSecretVersion = "latest"
+
+var (
+	secretNameRegex    = regexp.MustCompile(`^[A-Za-z0-9_-]{1,255}$`)
+	secretVersionRegex = regexp.MustCompile(`^(latest|[1-9][0-9]*)$`)
+	// project id or number
+	secretProjectRegex = regexp.MustCompile(`^([a-z][a-z0-9-]{4,28}[a-z0-9]|[0-9]+)$`)
+
+	// directories secrets can't be mounted to
+	reservedSecretMountPaths = []string{"/", "/dev", "/proc", "/sys"}
+)
+
+// SecretRef references a version of a Secret Manager secret.
+type SecretRef struct {
+	// empty for the project the function is deployed to
+	Project string
+	Secret  string
+	Version string
+}
+
+// parseSecretRef parses the value of an entry of the secrets setting:
+// SECRET:VERSION, projects/PROJECT/secrets/SECRET:VERSION or
+// projects/PROJECT/secrets/SECRET/versions/VERSION.
+func parseSecretRef(s string) (SecretRef, error) {
+	ref := SecretRef{}
+
+	name := s
+	if strings.HasPrefix(s, "projects/") {
+		parts := strings.Split(s, "/")
+		switch {
+		case len(parts) == 4 && parts[2] == "secrets":
+			name = parts[3]
+		case len(parts) == 6 && parts[2] == "secrets" && parts[4] == "versions":
+			name = parts[3] + ":" + parts[5]
+		default:
+			return ref, fmt.Errorf("invalid secret %q, expected projects/PROJECT/secrets/SECRET:VERSION", s)
+		}
+		ref.Project = parts[1]
+		if !secretProjectRegex.MatchString(ref.Project) {
+			return ref, fmt.Errorf("invalid project %q in secret %q", ref.Project, s)
+		}
+	}
+
+	idx := strings.LastIndex(name, ":")
+	if idx == -1 {
+		return ref, fmt.Errorf("missing version in secret %q, expected SECRET:VERSION, e.g. %s:1", s, name)
+	}
+	ref.Secret, ref.Version = name[:idx], name[idx+1:]
+	if !secretNameRegex.MatchString(ref.Secret) {
+		return ref, fmt.Errorf("invalid secret name %q in %q, only letters, digits, - and _ are allowed", ref.Secret, s)
+	}
+	if !secretVersionRegex.MatchString(ref.Version) {
+		return ref, fmt.Errorf("invalid version %q in secret %q, expected latest or a version number", ref.Version, s)
+	}
+	return ref, nil
+}
+
+// Name returns the resource name of the secret version, project is used if
+// the reference doesn't contain a project.
+func (r SecretRef) Name(project string) string {
+	if r.Project != "" {
+		project = r.Project
+	}
+	return fmt.Sprintf("projects/%s/secrets/%s/versions/%s", project, r.Secret, r.Version)
+}
+
+// isSecretMountPath returns true if the key of an entry of the secrets
+// setting is a path the secret is mounted to instead of a variable name.
+func isSecretMountPath(k string) bool {
+	return strings.HasPrefix(k, "/")
+}
+
+// validateSecretKey checks the key of an entry of the secrets setting:
+// either the name of an environment variable or a mount path, optionally
+// with the path of the file in the mounted directory as /DIR:/FILE.
+func validateSecretKey(k string) error {
+	if !isSecretMountPath(k) {
+		return validateEnvVarName(k)
+	}
+
+	paths := strings.SplitN(k, ":", 2)
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/") || path.Clean(p) != p {
+			return fmt.Errorf("invalid secret mount path %q, expected an absolute path like /etc/secrets or /etc/secrets:/key", k)
+		}
+	}
+	for _, reserved := range reservedSecretMountPaths {
+		if paths[0] == reserved || (reserved != "/" && strings.HasPrefix(paths[0], reserved+"/")) {
+			return fmt.Errorf("secrets can't be mounted to %s", paths[0])
+		}
+	}
+	return nil
+}
+
+// validateSecrets checks the entries of the secrets setting of f and the
+// secrets it removes.
+func validateSecrets(f Function) ConfigErrors {
+	errs := ConfigErrors{}
+	for _, k := range sortedKeys(f.Secrets) {
+		if err := validateSecretKey(k); err != nil {
+			errs.Add("secret %s: %s", k, err)
+		}
+		if _, err := parseSecretRef(f.Secrets[k]); err != nil {
+			errs.Add("secret %s: %s", k, err)
+		}
+		if _, ok := f.envFileVars[k]; ok || (len(f.Environment) > 0 && f.Environment[0][k] != "") {
+			errs.Add("%s is set as environment variable and secret", k)
+		}
+	}
+	for _, k := range f.RemoveSecrets {
+		if k == "" {
+			continue
+		}
+		if err := validateSecretKey(k); err != nil {
+			errs.Add("remove_secrets %s: %s", k, err)
+		}
+	}
+	return errs
+}
+
+// isProductionTarget returns true if the deploy target, e.g. of a drone
+// promotion, looks like a production environment.
+func isProductionTarget(target string) bool {
+	t := strings.ToLower(target)
+	return t == "prd" || t == "live" || strings.HasPrefix(t, "prod")
+}
+
+// secretWarnings warns about secrets that use the latest version when
+// deploying to production, a new version would be picked up without a
+// deploy.
+func secretWarnings(f Function, target string) []string {
+	if !isProductionTarget(target) {
+		return nil
+	}
+	res := []string{}
+	for _, k := range sortedKeys(f.Secrets) {
+		if ref, err := parseSecretRef(f.Secrets[k]); err == nil && ref.Version == latestSecretVersion {
+			res = append(res, fmt.Sprintf("secret %s uses the latest version of %s in %s, pin a version number", k, ref.Secret, target))
+		}
+	}
+	return res
+}
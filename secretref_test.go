@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	for _, tst := range []struct {
+		in       string
+		expected SecretRef
+		err      string
+	}{
+		{in: "db-password:latest", expected: SecretRef{Secret: "db-password", Version: "latest"}},
+		{in: "db_password:12", expected: SecretRef{Secret: "db_password", Version: "12"}},
+		{in: "projects/other-project/secrets/api-key:3", expected: SecretRef{Project: "other-project", Secret: "api-key", Version: "3"}},
+		{in: "projects/123456789/secrets/api-key/versions/latest", expected: SecretRef{Project: "123456789", Secret: "api-key", Version: "latest"}},
+		{in: "db-password", err: `missing version in secret "db-password", expected SECRET:VERSION, e.g. db-password:1`},
+		{in: "db-password:lastest", err: `invalid version "lastest"`},
+		{in: "db-password:0", err: `invalid version "0"`},
+		{in: "db password:1", err: `invalid secret name "db password"`},
+		{in: ":1", err: `invalid secret name ""`},
+		{in: "projects/p/secrets/api-key:1", err: `invalid project "p"`},
+		{in: "projects/other-project/api-key:1", err: "expected projects/PROJECT/secrets/SECRET:VERSION"},
+		{in: "projects/other-project/secrets/api-key/versions", err: "expected projects/PROJECT/secrets/SECRET:VERSION"},
+	} {
+		ref, err := parseSecretRef(tst.in)
+		if tst.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.err) {
+				t.Errorf("parseSecretRef(%s) expected %q, got: %v", tst.in, tst.err, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(ref, tst.expected) {
+			t.Errorf("parseSecretRef(%s) got: %#v   err: %v   expected: %#v", tst.in, ref, err, tst.expected)
+		}
+	}
+}
+
+func TestSecretRefName(t *testing.T) {
+	if n := (SecretRef{Secret: "s", Version: "1"}).Name("my-project"); n != "projects/my-project/secrets/s/versions/1" {
+		t.Errorf("got: %s", n)
+	}
+	if n := (SecretRef{Project: "other", Secret: "s", Version: "latest"}).Name("my-project"); n != "projects/other/secrets/s/versions/latest" {
+		t.Errorf("got: %s", n)
+	}
+}
+
+func TestValidateSecretKey(t *testing.T) {
+	for _, k := range []string{"API_KEY", "/etc/secrets", "/etc/secrets:/api-key", "/mnt/path"} {
+		if err := validateSecretKey(k); err != nil {
+			t.Errorf("validateSecretKey(%s) err: %s", k, err)
+		}
+	}
+
+	for k, e := range map[string]string{
+		"API-KEY":              `invalid environment variable name "API-KEY"`,
+		"PORT":                 "environment variable PORT is reserved",
+		"/etc/secrets/":        `invalid secret mount path "/etc/secrets/"`,
+		"/etc/../secrets":      `invalid secret mount path "/etc/../secrets"`,
+		"/etc/secrets:api-key": `invalid secret mount path "/etc/secrets:api-key"`,
+		"/":                    "secrets can't be mounted to /",
+		"/dev/secrets":         "secrets can't be mounted to /dev/secrets",
+		"/proc":                "secrets can't be mounted to /proc",
+	} {
+		if err := validateSecretKey(k); err == nil || !strings.Contains(err.Error(), e) {
+			t.Errorf("validateSecretKey(%s) expected %q, got: %v", k, e, err)
+		}
+	}
+}
+
+func TestValidateSecrets(t *testing.T) {
+	f := Function{
+		Secrets:       map[string]string{"API_KEY": "api-key:1", "/etc/secrets": "cert:latest"},
+		SecretsMode:   "merge",
+		RemoveSecrets: []string{"OLD_KEY", "/etc/old"},
+	}
+	if errs := validateSecrets(f); len(errs) > 0 {
+		t.Errorf("validateSecrets() err: %s", errs)
+	}
+
+	f = Function{
+		Secrets:       map[string]string{"API_KEY": "api-key", "DB_PASSWORD": "db:1", "/": "x:1"},
+		Environment:   []map[string]string{{"DB_PASSWORD": "plain"}},
+		RemoveSecrets: []string{"OLD-KEY"},
+	}
+	errs := validateSecrets(f)
+	for _, e := range []string{
+		"secret API_KEY: missing version",
+		"DB_PASSWORD is set as environment variable and secret",
+		"secret /: secrets can't be mounted to /",
+		"remove_secrets OLD-KEY: invalid environment variable name",
+	} {
+		if !strings.Contains(errs.Error(), e) {
+			t.Errorf("expected %q in errors, got: %s", e, errs)
+		}
+	}
+}
+
+func TestSecretWarnings(t *testing.T) {
+	f := Function{Secrets: map[string]string{"API_KEY": "api-key:latest", "DB_PASSWORD": "db:3"}}
+
+	for _, target := range []string{"", "staging", "dev"} {
+		if w := secretWarnings(f, target); len(w) > 0 {
+			t.Errorf("unexpected warnings for %q: %#v", target, w)
+		}
+	}
+	for _, target := range []string{"production", "prod", "Prod-EU", "live"} {
+		w := secretWarnings(f, target)
+		if len(w) != 1 || !strings.Contains(w[0], "secret API_KEY uses the latest version of api-key in "+target) {
+			t.Errorf("expected a warning for %q, got: %#v", target, w)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// env secrets are passed as plain environment variables, the default
+	envSecretsModeEnv = "env"
+	// env secrets are stored in Secret Manager and referenced as secrets
+	envSecretsModeSecretManager = "secret_manager"
+
+	defaultEnvSecretsPrefix = "drone-gcf-"
+
+	// annotations of the secret with the version the plugin stored last and
+	// the digest of its value, so the value doesn't have to be accessed
+	syncedVersionAnnotation = "drone-gcf-version"
+	syncedDigestAnnotation  = "drone-gcf-sha256"
+)
+
+var validEnvSecretsModes = []string{envSecretsModeEnv, envSecretsModeSecretManager}
+
+// syncedSecretName returns the Secret Manager secret the env secret with
+// the name k is stored in.
+func syncedSecretName(prefix string, k string) string {
+	return prefix + strings.ToLower(k)
+}
+
+// validateEnvSecretsSync checks the env_secrets_mode and env_secrets_prefix
+// settings.
+func validateEnvSecretsSync(cfg *Config) ConfigErrors {
+	errs := ConfigErrors{}
+	if !containsString(validEnvSecretsModes, cfg.EnvSecretsMode) {
+		errs.Add("invalid env_secrets_mode [%s], must be one of: %s", cfg.EnvSecretsMode, strings.Join(validEnvSecretsModes, ", "))
+		return errs
+	}
+	if cfg.EnvSecretsMode != envSecretsModeSecretManager {
+		return errs
+	}
+	for _, s := range cfg.EnvSecrets {
+		if name := syncedSecretName(cfg.EnvSecretsPrefix, envSecretName(s)); !secretNameRegex.MatchString(name) {
+			errs.Add("env secret %s can't be stored as secret %q, check env_secrets_prefix", envSecretName(s), name)
+		}
+	}
+	return errs
+}
+
+// Secret is the metadata of a secret.
+type Secret struct {
+	Name        string            `json:"name"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// GetSecret returns the secret with the resource name projects/P/secrets/S,
+// nil if it doesn't exist.
+func (c *SecretManagerClient) GetSecret(name string) (*Secret, error) {
+	s := &Secret{}
+	status, err := c.do(http.MethodGet, name, nil, s)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// CreateSecret creates the secret id in project with automatic replication.
+func (c *SecretManagerClient) CreateSecret(project string, id string) error {
+	body := `{"replication": {"automatic": {}}, "labels": {"managed-by": "drone-gcf"}}`
+	name := fmt.Sprintf("projects/%s/secrets?secretId=%s", project, url.QueryEscape(id))
+	status, err := c.do(http.MethodPost, name, strings.NewReader(body), nil)
+	if err == nil && status == http.StatusNotFound {
+		err = fmt.Errorf("projects/%s not found", project)
+	}
+	return err
+}
+
+// SetAnnotations replaces the annotations of the secret.
+func (c *SecretManagerClient) SetAnnotations(secret string, annotations map[string]string) error {
+	body, err := json.Marshal(Secret{Annotations: annotations})
+	if err != nil {
+		return err
+	}
+	status, err := c.do(http.MethodPatch, secret+"?updateMask=annotations", bytes.NewReader(body), nil)
+	if err == nil && status == http.StatusNotFound {
+		err = fmt.Errorf("%s not found", secret)
+	}
+	return err
+}
+
+// AddVersion adds a version with the value data to the secret and returns
+// the resource name of the version.
+func (c *SecretManagerClient) AddVersion(secret string, data []byte) (string, error) {
+	body := fmt.Sprintf(`{"payload": {"data": %q}}`, base64.StdEncoding.EncodeToString(data))
+	res := &SecretVersion{}
+	status, err := c.do(http.MethodPost, secret+":addVersion", strings.NewReader(body), res)
+	if err == nil && status == http.StatusNotFound {
+		err = fmt.Errorf("%s not found", secret)
+	}
+	return res.Name, err
+}
+
+// syncEnvSecrets stores the env secrets in Secret Manager, a new version is
+// only added if the value changed, and references the versions in the
+// secrets of the functions that receive them instead of passing them as
+// environment variables. Each secret is stored with the client of the first
+// function that receives it from clients.
+func syncEnvSecrets(cfg *Config, clients func(f Function) (*SecretManagerClient, error)) error {
+	versions := map[string]string{}
+	for _, s := range cfg.EnvSecrets {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		client, err := clients(envSecretReceiver(cfg, kv[0]))
+		if err != nil {
+			return err
+		}
+		v, err := syncEnvSecret(cfg, client, kv[0], kv[1])
+		if err != nil {
+			return fmt.Errorf("can't store env secret %s in Secret Manager: %s", kv[0], err)
+		}
+		versions[kv[0]] = v
+	}
+
+	for i, f := range cfg.Functions {
+		secrets := map[string]string{}
+		for k, v := range f.Secrets {
+			secrets[k] = v
+		}
+		vars := functionEnvVars(cfg, f)
+		for _, s := range functionEnvSecrets(cfg, f) {
+			k := envSecretName(s)
+			if _, ok := secrets[k]; ok {
+				// set explicitly
+				continue
+			}
+			if _, ok := vars[k]; ok {
+				// overridden by the env_vars_file or environment
+				continue
+			}
+			secrets[k] = syncedSecretName(cfg.EnvSecretsPrefix, k) + ":" + versions[k]
+		}
+		cfg.Functions[i].Secrets = secrets
+	}
+	return nil
+}
+
+// envSecretReceiver returns the first function that receives the env secret
+// k, or a function without settings if none does.
+func envSecretReceiver(cfg *Config, k string) Function {
+	for _, f := range cfg.Functions {
+		for _, s := range functionEnvSecrets(cfg, f) {
+			if envSecretName(s) == k {
+				return f
+			}
+		}
+	}
+	return Function{}
+}
+
+// syncEnvSecret stores the value of the env secret k and returns the number
+// of the version that contains it.
+func syncEnvSecret(cfg *Config, client *SecretManagerClient, k string, value string) (string, error) {
+	id := syncedSecretName(cfg.EnvSecretsPrefix, k)
+	secret := fmt.Sprintf("projects/%s/secrets/%s", cfg.Project, id)
+	if cfg.DryRun {
+		log.Printf("Dry run, not storing env secret %s in %s", k, secret)
+		return latestSecretVersion, nil
+	}
+
+	s, err := client.GetSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	digest := secretDigest(secret, value)
+	if s == nil {
+		if err := client.CreateSecret(cfg.Project, id); err != nil {
+			return "", err
+		}
+		log.Printf("Created secret %s for env secret %s", secret, k)
+		s = &Secret{Name: secret}
+	} else if v := s.Annotations[syncedVersionAnnotation]; v != "" && s.Annotations[syncedDigestAnnotation] == digest {
+		// the version might have been disabled or destroyed since
+		name := secret + "/versions/" + v
+		sv, err := client.GetVersion(name)
+		if err != nil {
+			return "", err
+		}
+		if sv != nil && (sv.State == "" || sv.State == "ENABLED") {
+			log.Printf("Env secret %s is unchanged in %s", k, name)
+			return v, nil
+		}
+	}
+
+	name, err := client.AddVersion(secret, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", fmt.Errorf("%s: no version in the response", secret)
+	}
+	log.Printf("Stored env secret %s in %s", k, name)
+
+	annotations := map[string]string{}
+	for ak, av := range s.Annotations {
+		annotations[ak] = av
+	}
+	annotations[syncedVersionAnnotation] = versionNumber(name)
+	annotations[syncedDigestAnnotation] = digest
+	if err := client.SetAnnotations(secret, annotations); err != nil {
+		return "", err
+	}
+	return versionNumber(name), nil
+}
+
+// secretDigest returns the digest of the value stored in the secret, the
+// name is included so equal values don't have equal digests.
+func secretDigest(secret string, value string) string {
+	sum := sha256.Sum256([]byte(secret + "\x00" + value))
+	return hex.EncodeToString(sum[:])
+}
+
+// versionNumber returns the version of the resource name of a secret
+// version.
+func versionNumber(name string) string {
+	if idx := strings.LastIndex(name, "/"); idx != -1 {
+		return name[idx+1:]
+	}
+	return name
+}
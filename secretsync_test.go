@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateEnvSecretsSync(t *testing.T) {
+	cfg := &Config{EnvSecretsMode: "secret_manager", EnvSecretsPrefix: "drone-gcf-", EnvSecrets: []string{"DB_PASSWORD=pw"}}
+	if errs := validateEnvSecretsSync(cfg); len(errs) > 0 {
+		t.Errorf("validateEnvSecretsSync() err: %s", errs)
+	}
+
+	cfg.EnvSecretsPrefix = "my app/"
+	if errs := validateEnvSecretsSync(cfg); len(errs) == 0 || !strings.Contains(errs.Error(), `env secret DB_PASSWORD can't be stored as secret "my app/db_password"`) {
+		t.Errorf("expected an invalid name error, got: %v", errs)
+	}
+
+	cfg.EnvSecretsMode = "secretmanager"
+	if errs := validateEnvSecretsSync(cfg); len(errs) == 0 || !strings.Contains(errs.Error(), "invalid env_secrets_mode [secretmanager]") {
+		t.Errorf("expected an invalid mode error, got: %v", errs)
+	}
+}
+
+func TestSyncEnvSecrets(t *testing.T) {
+	fsm, srv := newFakeSecretManager(t)
+	apiKey := "projects/my-project/secrets/drone-gcf-api_key"
+	fsm.secrets[apiKey] = [][]byte{[]byte("old"), []byte("key")}
+	fsm.versions[apiKey+"/versions/2"] = "ENABLED"
+	fsm.annotations[apiKey] = map[string]string{"owner": "team", "drone-gcf-version": "2", "drone-gcf-sha256": secretDigest(apiKey, "key")}
+	client := NewSecretManagerClient(srv.URL, "test-token")
+
+	newCfg := func() *Config {
+		return &Config{
+			Action:           "deploy",
+			Project:          "my-project",
+			EnvSecretsMode:   "secret_manager",
+			EnvSecretsPrefix: "drone-gcf-",
+			EnvSecrets:       []string{"DB_PASSWORD=pw", "API_KEY=key"},
+			Functions: Functions{
+				{Name: "All", Runtime: "go121", Trigger: "http", EnvironmentDelimiter: ":|:", Secrets: map[string]string{"/etc/certs": "cert:1"}},
+				{Name: "Db", Runtime: "go121", Trigger: "http", EnvironmentDelimiter: ":|:", EnvSecrets: []string{"DB_PASSWORD"}},
+				{Name: "Inline", Runtime: "go121", Trigger: "http", EnvironmentDelimiter: ":|:", Environment: []map[string]string{{"API_KEY": "inline"}}},
+			},
+		}
+	}
+
+	cfg := newCfg()
+	if err := syncEnvSecrets(cfg, secretManagerClients(client)); err != nil {
+		t.Fatalf("syncEnvSecrets() err: %s", err)
+	}
+	// db_password was created, api_key is unchanged
+	if fsm.added != 1 || string(fsm.secrets["projects/my-project/secrets/drone-gcf-db_password"][0]) != "pw" {
+		t.Errorf("unexpected secrets: %#v   added: %d", fsm.secrets, fsm.added)
+	}
+
+	expected := []map[string]string{
+		{"/etc/certs": "cert:1", "DB_PASSWORD": "drone-gcf-db_password:1", "API_KEY": "drone-gcf-api_key:2"},
+		{"DB_PASSWORD": "drone-gcf-db_password:1"},
+		{"DB_PASSWORD": "drone-gcf-db_password:1"},
+	}
+	for i, f := range cfg.Functions {
+		if !reflect.DeepEqual(f.Secrets, expected[i]) {
+			t.Errorf("function %s got secrets: %#v   expected: %#v", f.Name, f.Secrets, expected[i])
+		}
+	}
+
+	plan, err := CreateExecutionPlan(cfg)
+	if err != nil {
+		t.Fatalf("CreateExecutionPlan() err: %s", err)
+	}
+	for _, step := range plan.Steps {
+		args := strings.Join(step, " ")
+		if strings.Contains(args, "=pw") || strings.Contains(args, "API_KEY=key") {
+			t.Errorf("env secret values passed as variables: %s", args)
+		}
+	}
+	if args := strings.Join(plan.Steps[2], " "); !strings.Contains(args, "--set-env-vars ^:|:^API_KEY=inline") || !strings.Contains(args, "--set-secrets ^:|:^DB_PASSWORD=drone-gcf-db_password:1") {
+		t.Errorf("unexpected args: %s", args)
+	}
+
+	// the stored value is recognized by its digest, it's never accessed
+	cfg = newCfg()
+	if err := syncEnvSecrets(cfg, secretManagerClients(client)); err != nil {
+		t.Fatalf("syncEnvSecrets() err: %s", err)
+	}
+	if fsm.added != 1 || !reflect.DeepEqual(cfg.Functions[0].Secrets, expected[0]) {
+		t.Errorf("expected unchanged secrets, got: %#v   added: %d", cfg.Functions[0].Secrets, fsm.added)
+	}
+
+	// a changed value is stored as new version
+	cfg = newCfg()
+	cfg.EnvSecrets = []string{"DB_PASSWORD=new-pw"}
+	if err := syncEnvSecrets(cfg, secretManagerClients(client)); err != nil {
+		t.Fatalf("syncEnvSecrets() err: %s", err)
+	}
+	if fsm.added != 2 || cfg.Functions[1].Secrets["DB_PASSWORD"] != "drone-gcf-db_password:2" {
+		t.Errorf("expected a new version, got: %#v   added: %d", cfg.Functions[1].Secrets, fsm.added)
+	}
+
+	// so is an unchanged value whose version was disabled or destroyed
+	fsm.versions[apiKey+"/versions/2"] = "DESTROYED"
+	cfg = newCfg()
+	cfg.EnvSecrets = []string{"API_KEY=key"}
+	if err := syncEnvSecrets(cfg, secretManagerClients(client)); err != nil {
+		t.Fatalf("syncEnvSecrets() err: %s", err)
+	}
+	if fsm.added != 3 || cfg.Functions[0].Secrets["API_KEY"] != "drone-gcf-api_key:3" {
+		t.Errorf("expected a new version, got: %#v   added: %d", cfg.Functions[0].Secrets, fsm.added)
+	}
+	// the other annotations are kept
+	if a := fsm.annotations[apiKey]; a["owner"] != "team" || a["drone-gcf-version"] != "3" || a["drone-gcf-sha256"] != secretDigest(apiKey, "key") {
+		t.Errorf("unexpected annotations: %#v", a)
+	}
+
+	// nothing is stored in dry runs
+	cfg = newCfg()
+	cfg.DryRun = true
+	cfg.EnvSecrets = []string{"OTHER=x"}
+	requests := fsm.requests
+	if err := syncEnvSecrets(cfg, secretManagerClients(client)); err != nil {
+		t.Fatalf("syncEnvSecrets() err: %s", err)
+	}
+	if fsm.requests != requests || cfg.Functions[0].Secrets["OTHER"] != "drone-gcf-other:latest" {
+		t.Errorf("unexpected dry run, requests: %d   secrets: %#v", fsm.requests-requests, cfg.Functions[0].Secrets)
+	}
+
+	cfg = newCfg()
+	if err := syncEnvSecrets(cfg, secretManagerClients(NewSecretManagerClient(srv.URL, "wrong-token"))); err == nil || !strings.Contains(err.Error(), "can't store env secret DB_PASSWORD in Secret Manager") {
+		t.Errorf("expected an error, got: %v", err)
+	}
+}
+
+func TestParseConfigEnvSecretsMode(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("PLUGIN_ACTION", "deploy")
+	os.Setenv("PLUGIN_TOKEN", validGCPKey)
+	os.Setenv("PLUGIN_ENV_SECRET_DB_PASSWORD", "pw")
+	os.Setenv("PLUGIN_ENV_SECRETS_MODE", "secret_manager")
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"F":[{"trigger":"http","runtime":"go121"}]}]`)
+
+	cfg, err := parseConfig()
+	if err != nil {
+		t.Fatalf("parseConfig() err: %s", err)
+	}
+	if cfg.EnvSecretsMode != "secret_manager" || cfg.EnvSecretsPrefix != "drone-gcf-" || !reflect.DeepEqual(cfg.EnvSecrets, []string{"DB_PASSWORD=pw"}) {
+		t.Errorf("unexpected config: %s %s %#v", cfg.EnvSecretsMode, cfg.EnvSecretsPrefix, cfg.EnvSecrets)
+	}
+
+	// the env secrets aren't passed as variables before they're stored
+	plan, err := CreateExecutionPlan(cfg)
+	if err != nil {
+		t.Fatalf("CreateExecutionPlan() err: %s", err)
+	}
+	if args := strings.Join(plan.Steps[0], " "); strings.Contains(args, "--set-env-vars") {
+		t.Errorf("unexpected env vars: %s", args)
+	}
+
+	os.Setenv("PLUGIN_ENV_SECRETS_MODE", "vault")
+	if _, err := parseConfig(); err == nil || !strings.Contains(err.Error(), "invalid env_secrets_mode [vault]") {
+		t.Errorf("expected an invalid mode error, got: %v", err)
+	}
+}
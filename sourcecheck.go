@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sourceChecks are the preflight checks of a function's source directory,
+// keyed by the family of the function's runtime.
+var sourceChecks = map[string]func(src string, f Function) ConfigErrors{
+	"go":     checkGoSource,
+	"python": checkPythonSource,
+	"nodejs": checkNodeSource,
+}
+
+// dependencyChecks compare the imports of a function's source with the
+// dependencies it declares, keyed by the family of the function's runtime.
+// Finding the imports is a heuristic that can be fooled, e.g. by imports in
+// comments, so outside of the validate action they only log warnings.
+var dependencyChecks = map[string]func(src string, f Function) ConfigErrors{
+	"nodejs": checkNodeDependencies,
+}
+
+// checkSource runs the checks for the runtime family of f on the source in
+// src, runtimes without checks are skipped.
+func checkSource(src string, f Function) error {
+	check, ok := sourceChecks[runtimeFamily(f.Runtime)]
+	if !ok {
+		return nil
+	}
+	return check(src, f).Err()
+}
+
+// checkDependencies runs the dependency checks for the runtime family of f
+// on the source in src, runtimes without checks are skipped.
+func checkDependencies(src string, f Function) error {
+	check, ok := dependencyChecks[runtimeFamily(f.Runtime)]
+	if !ok {
+		return nil
+	}
+	return check(src, f).Err()
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+var (
+	goModGoRegex     = regexp.MustCompile(`(?m)^go\s+(\d+)\.(\d+)`)
+	goModModuleRegex = regexp.MustCompile(`(?m)^module\s+\S+`)
+	goModRequire     = regexp.MustCompile(`(?m)^require\s`)
+	goPackageRegex   = regexp.MustCompile(`(?m)^package\s+(\w+)`)
+)
+
+func checkGoSource(src string, f Function) ConfigErrors {
+	errs := ConfigErrors{}
+
+	if fileExists(filepath.Join(src, "go.work")) {
+		errs.Add("go.work is not supported by Cloud Functions, remove it from %s", src)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(src, "*.go"))
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			errs.Add("%s", err)
+			continue
+		}
+		if m := goPackageRegex.FindSubmatch(b); m != nil && string(m[1]) == "main" {
+			errs.Add("%s is in package main, functions must be in a library package", filepath.Base(file))
+		}
+	}
+
+	mod, err := ioutil.ReadFile(filepath.Join(src, "go.mod"))
+	if os.IsNotExist(err) {
+		// go111 and go113 still support building without modules
+		if rv, ok := runtimeVersion(f.Runtime); ok && rv.compare(version{major: 1, minor: 16}) >= 0 {
+			errs.Add("missing go.mod in %s, it's required by runtime %s", src, f.Runtime)
+		}
+		return errs
+	}
+	if err != nil {
+		errs.Add("%s", err)
+		return errs
+	}
+
+	if !goModModuleRegex.Match(mod) {
+		errs.Add("go.mod in %s is missing the module directive", src)
+	}
+
+	if m := goModGoRegex.FindSubmatch(mod); m != nil {
+		major, _ := strconv.Atoi(string(m[1]))
+		minor, _ := strconv.Atoi(string(m[2]))
+		required := version{major: major, minor: minor}
+		if rv, ok := runtimeVersion(f.Runtime); ok && required.compare(rv) > 0 {
+			errs.Add("go.mod requires go %s but runtime %s provides go %s", required, f.Runtime, rv)
+		}
+	}
+
+	if goModRequire.Match(mod) && !fileExists(filepath.Join(src, "go.sum")) {
+		errs.Add("missing go.sum in %s, go.mod has dependencies", src)
+	}
+
+	return errs
+}
+
+func checkPythonSource(src string, f Function) ConfigErrors {
+	errs := ConfigErrors{}
+
+	if !fileExists(filepath.Join(src, "main.py")) {
+		errs.Add("missing main.py in %s", src)
+	}
+	if !fileExists(filepath.Join(src, "requirements.txt")) {
+		errs.Add("missing requirements.txt in %s", src)
+	}
+
+	for _, venv := range []string{"venv", ".venv", "env"} {
+		if fileExists(filepath.Join(src, venv, "pyvenv.cfg")) {
+			errs.Add("virtualenv %s must not be deployed, add it to .gcloudignore", venv)
+		}
+	}
+
+	return errs
+}
+
+// packageJSON contains the parts of package.json that are checked.
+type packageJSON struct {
+	Main                 string              `json:"main"`
+	Dependencies         map[string]string   `json:"dependencies"`
+	DevDependencies      map[string]string   `json:"devDependencies"`
+	OptionalDependencies map[string]string   `json:"optionalDependencies"`
+	PeerDependencies     map[string]string   `json:"peerDependencies"`
+	BundledDependencies  bundledDependencies `json:"bundledDependencies"`
+	BundleDependencies   bundledDependencies `json:"bundleDependencies"`
+	Scripts              map[string]string   `json:"scripts"`
+}
+
+// bundledDependencies are the names of the bundled packages, npm also
+// accepts true to bundle all dependencies which are listed anyway.
+type bundledDependencies []string
+
+func (b *bundledDependencies) UnmarshalJSON(data []byte) error {
+	var all bool
+	if err := json.Unmarshal(data, &all); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(b))
+}
+
+// installs returns true if the package m is installed when deploying.
+func (pkg packageJSON) installs(m string) bool {
+	for _, deps := range []map[string]string{pkg.Dependencies, pkg.OptionalDependencies, pkg.PeerDependencies} {
+		if _, ok := deps[m]; ok {
+			return true
+		}
+	}
+	return containsString(pkg.BundledDependencies, m) || containsString(pkg.BundleDependencies, m)
+}
+
+// hasBuildStep returns true if the source is built while deploying, e.g.
+// from TypeScript, so the main file doesn't have to exist yet.
+func (pkg packageJSON) hasBuildStep() bool {
+	return pkg.Scripts["gcp-build"] != ""
+}
+
+var (
+	nodeImportRegex = regexp.MustCompile(`(?:require\(\s*|import\(\s*|from\s+|import\s+)['"]([^'"]+)['"]`)
+
+	// directories with tests, their imports are usually devDependencies
+	nodeTestDirs = []string{"test", "tests", "__tests__", "spec", "__mocks__"}
+
+	// modules that come with node and don't need to be listed as dependency
+	nodeBuiltinModules = []string{
+		"assert", "async_hooks", "buffer", "child_process", "cluster", "console", "constants",
+		"crypto", "dgram", "diagnostics_channel", "dns", "domain", "events", "fs", "http", "http2",
+		"https", "inspector", "module", "net", "os", "path", "perf_hooks", "process", "punycode",
+		"querystring", "readline", "repl", "stream", "string_decoder", "sys", "timers", "tls",
+		"trace_events", "tty", "url", "util", "v8", "vm", "wasi", "worker_threads", "zlib",
+	}
+)
+
+func checkNodeSource(src string, f Function) ConfigErrors {
+	errs := ConfigErrors{}
+
+	if b, err := ioutil.ReadFile(filepath.Join(src, ".npmrc")); err == nil && strings.Contains(string(b), "_authToken") {
+		errs.Add(".npmrc in %s contains an auth token, it must not be deployed", src)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(src, "package.json"))
+	if os.IsNotExist(err) {
+		errs.Add("missing package.json in %s", src)
+		return errs
+	}
+	if err != nil {
+		errs.Add("%s", err)
+		return errs
+	}
+
+	pkg := packageJSON{}
+	if err := json.Unmarshal(b, &pkg); err != nil {
+		errs.Add("invalid package.json in %s: %s", src, err)
+		return errs
+	}
+
+	if pkg.Main != "" && !fileExists(filepath.Join(src, pkg.Main)) && !pkg.hasBuildStep() {
+		errs.Add("main file %s from package.json doesn't exist", pkg.Main)
+	}
+
+	return errs
+}
+
+// checkNodeDependencies checks that the packages imported by the source are
+// installed when deploying. Problems with package.json itself are reported
+// by checkNodeSource.
+func checkNodeDependencies(src string, f Function) ConfigErrors {
+	errs := ConfigErrors{}
+
+	b, err := ioutil.ReadFile(filepath.Join(src, "package.json"))
+	if err != nil {
+		return errs
+	}
+	pkg := packageJSON{}
+	if err := json.Unmarshal(b, &pkg); err != nil {
+		return errs
+	}
+
+	imports, err := nodeImports(src)
+	if err != nil {
+		errs.Add("%s", err)
+		return errs
+	}
+	for _, m := range imports {
+		if pkg.installs(m) {
+			continue
+		}
+		if _, ok := pkg.DevDependencies[m]; ok {
+			errs.Add("dependency %s is only listed in devDependencies of package.json, these aren't installed when deploying", m)
+			continue
+		}
+		errs.Add("dependency %s is missing in package.json", m)
+	}
+
+	return errs
+}
+
+// nodeImports returns the packages imported by the javascript files in src
+// that are deployed, skipping relative imports, builtin modules, tests and
+// everything that's ignored by .gcloudignore.
+func nodeImports(src string) ([]string, error) {
+	rules, err := loadIgnoreRules(src)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+	err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == src {
+			return nil
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		ignored := rules.Ignored(filepath.ToSlash(rel), fi.IsDir())
+		if fi.IsDir() {
+			if ignored || fi.Name() == "node_modules" || strings.HasPrefix(fi.Name(), ".") || containsString(nodeTestDirs, fi.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignored {
+			return nil
+		}
+		switch filepath.Ext(p) {
+		case ".js", ".mjs", ".cjs":
+		default:
+			return nil
+		}
+		if strings.Contains(fi.Name(), ".test.") || strings.Contains(fi.Name(), ".spec.") {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		for _, m := range nodeImportRegex.FindAllSubmatch(b, -1) {
+			if name := nodePackageName(string(m[1])); name != "" {
+				found[name] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(found))
+	for k := range found {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// nodePackageName returns the package name of an import path, e.g. "lodash"
+// for "lodash/fp" or "@google-cloud/storage" for "@google-cloud/storage/x".
+// Relative imports and builtin modules return an empty string.
+func nodePackageName(p string) string {
+	if strings.HasPrefix(p, ".") || strings.HasPrefix(p, "/") || strings.HasPrefix(p, "node:") {
+		return ""
+	}
+	parts := strings.Split(p, "/")
+	if strings.HasPrefix(p, "@") {
+		if len(parts) < 2 {
+			return ""
+		}
+		return parts[0] + "/" + parts[1]
+	}
+	if containsString(nodeBuiltinModules, parts[0]) {
+		return ""
+	}
+	return parts[0]
+}
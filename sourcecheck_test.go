@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckSource(t *testing.T) {
+	goMod := "module example.com/function\n\ngo 1.21\n"
+
+	for _, tst := range []struct {
+		name           string
+		files          map[string]string
+		f              Function
+		expectedErrors []string
+	}{
+		{
+			name:  "go ok",
+			files: map[string]string{"go.mod": goMod, "function.go": goHTTPFunction},
+			f:     Function{Runtime: "go121"},
+		},
+		{
+			name:  "go with dependencies",
+			files: map[string]string{"go.mod": goMod + "\nrequire github.com/x/y v1.0.0\n", "go.sum": "", "function.go": goHTTPFunction},
+			f:     Function{Runtime: "go121"},
+		},
+		{
+			name:  "go113 without go.mod",
+			files: map[string]string{"function.go": goHTTPFunction},
+			f:     Function{Runtime: "go113"},
+		},
+		{
+			name:           "go missing go.mod",
+			files:          map[string]string{"function.go": goHTTPFunction},
+			f:              Function{Runtime: "go121"},
+			expectedErrors: []string{"missing go.mod"},
+		},
+		{
+			name:           "go version mismatch",
+			files:          map[string]string{"go.mod": "module example.com/function\n\ngo 1.22.1\n", "function.go": goHTTPFunction},
+			f:              Function{Runtime: "go121"},
+			expectedErrors: []string{"go.mod requires go 1.22 but runtime go121 provides go 1.21"},
+		},
+		{
+			name:           "go disallowed files",
+			files:          map[string]string{"go.mod": "go 1.21\n\nrequire (\n\tgithub.com/x/y v1.0.0\n)\n", "go.work": "", "main.go": "package main\n", "main_test.go": "package main\n"},
+			f:              Function{Runtime: "go121"},
+			expectedErrors: []string{"go.work is not supported", "main.go is in package main", "missing the module directive", "missing go.sum"},
+		},
+		{
+			name:  "python ok",
+			files: map[string]string{"main.py": "", "requirements.txt": ""},
+			f:     Function{Runtime: "python311"},
+		},
+		{
+			name:           "python missing manifests and virtualenv",
+			files:          map[string]string{"app.py": "", ".venv/pyvenv.cfg": ""},
+			f:              Function{Runtime: "python311"},
+			expectedErrors: []string{"missing main.py", "missing requirements.txt", "virtualenv .venv must not be deployed"},
+		},
+		{
+			name: "node ok",
+			files: map[string]string{
+				"package.json":          `{"main": "index.js", "dependencies": {"@google-cloud/storage": "^7.0.0", "lodash": "^4.0.0"}}`,
+				"index.js":              "const fs = require('fs');\nconst { Storage } = require('@google-cloud/storage');\nconst fp = require('lodash/fp');\nconst util = require('./util');\nconst p = require('node:path');\n",
+				"util.js":               "module.exports = {};\n",
+				"index.test.js":         "const sinon = require('sinon');\n",
+				"node_modules/x/a.js":   "require('not-checked');\n",
+				".cache/something.js":   "require('not-checked');\n",
+				"lib/esm.mjs":           "import { Storage } from '@google-cloud/storage';\nimport 'lodash';\n",
+				"lib/not-javascript.md": "require('not-checked')",
+			},
+			f: Function{Runtime: "nodejs20"},
+		},
+		{
+			name: "node tests and ignored files",
+			files: map[string]string{
+				"package.json":         `{"main": "index.js", "devDependencies": {"chai": "^4.0.0", "mocha": "^10.0.0"}}`,
+				"index.js":             "exports.hello = (req, res) => {};\n",
+				"test/index.js":        "const { expect } = require('chai');\n",
+				"__tests__/hello.js":   "require('chai');\n",
+				".gcloudignore":        "scripts/\n*.config.js\n",
+				"scripts/release.js":   "require('mocha');\n",
+				"lib/eslint.config.js": "require('mocha');\n",
+			},
+			f: Function{Runtime: "nodejs20"},
+		},
+		{
+			name: "node missing dependencies",
+			files: map[string]string{
+				"package.json": `{"main": "app.js", "devDependencies": {"axios": "^1.0.0"}}`,
+				"index.js":     "const axios = require('axios');\nimport express from 'express';\n",
+				".npmrc":       "//registry.npmjs.org/:_authToken=abc\n",
+			},
+			f:              Function{Runtime: "nodejs20"},
+			expectedErrors: []string{"auth token", "main file app.js from package.json doesn't exist", "dependency axios is only listed in devDependencies", "dependency express is missing in package.json"},
+		},
+		{
+			name: "node typescript",
+			files: map[string]string{
+				"package.json": `{"main": "build/index.js", "scripts": {"gcp-build": "tsc"}, "dependencies": {"express": "^4.0.0"}}`,
+				"src/index.ts": "import express from 'express';\n",
+			},
+			f: Function{Runtime: "nodejs20"},
+		},
+		{
+			name: "node other dependency fields",
+			files: map[string]string{
+				"package.json": `{"optionalDependencies": {"sharp": "^0.33.0"}, "peerDependencies": {"express": "^4.0.0"}, "bundledDependencies": ["local-lib"], "bundleDependencies": true}`,
+				"index.js":     "const sharp = require('sharp');\nconst express = require('express');\nconst lib = require('local-lib');\n",
+			},
+			f: Function{Runtime: "nodejs20"},
+		},
+		{
+			name:           "node missing package.json",
+			files:          map[string]string{"index.js": ""},
+			f:              Function{Runtime: "nodejs20"},
+			expectedErrors: []string{"missing package.json"},
+		},
+		{
+			name:           "node invalid package.json",
+			files:          map[string]string{"package.json": "{", "index.js": ""},
+			f:              Function{Runtime: "nodejs20"},
+			expectedErrors: []string{"invalid package.json"},
+		},
+		{
+			name:  "ruby isn't checked",
+			files: map[string]string{"app.rb": ""},
+			f:     Function{Runtime: "ruby30"},
+		},
+	} {
+		dir := t.TempDir()
+		writeFiles(t, dir, tst.files)
+
+		errs := ConfigErrors{}
+		errs.Append("", checkSource(dir, tst.f))
+		errs.Append("", checkDependencies(dir, tst.f))
+		err := errs.Err()
+		if len(tst.expectedErrors) == 0 {
+			if err != nil {
+				t.Errorf("%s: checkSource() failed, err: %s", tst.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: checkSource() should have failed", tst.name)
+			continue
+		}
+		if errs, ok := err.(ConfigErrors); ok && len(errs) != len(tst.expectedErrors) {
+			t.Errorf("%s: expected %d errors, got: %s", tst.name, len(tst.expectedErrors), err)
+		}
+		for _, e := range tst.expectedErrors {
+			if !strings.Contains(err.Error(), e) {
+				t.Errorf("%s: expected error to contain %q, got: %s", tst.name, e, err)
+			}
+		}
+	}
+}
+
+func TestNodePackageName(t *testing.T) {
+	for in, expected := range map[string]string{
+		"lodash":                 "lodash",
+		"lodash/fp":              "lodash",
+		"@google-cloud/storage":  "@google-cloud/storage",
+		"@google-cloud/pubsub/x": "@google-cloud/pubsub",
+		"@broken":                "",
+		"./local":                "",
+		"../up":                  "",
+		"/abs":                   "",
+		"fs":                     "",
+		"fs/promises":            "",
+		"node:crypto":            "",
+	} {
+		if res := nodePackageName(in); res != expected {
+			t.Errorf("nodePackageName(%s) got: %q   expected: %q", in, res, expected)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStorageEndpoint = "https://storage.googleapis.com"
+
+	// used if staging_bucket doesn't contain a path
+	defaultStagingPrefix = "drone-gcf/"
+)
+
+var bucketNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,220}[a-z0-9]$`)
+
+// parseStagingBucket parses the staging_bucket setting which is either a
+// bucket name or a gs:// url with an optional path for the objects.
+func parseStagingBucket(s string) (string, string, error) {
+	bucket := strings.TrimPrefix(s, "gs://")
+	prefix := defaultStagingPrefix
+	if idx := strings.Index(bucket, "/"); idx != -1 {
+		bucket, prefix = bucket[:idx], strings.Trim(bucket[idx+1:], "/")+"/"
+		if prefix == "/" {
+			prefix = ""
+		}
+	}
+	if !bucketNameRegex.MatchString(bucket) {
+		return "", "", fmt.Errorf("invalid bucket name %q", bucket)
+	}
+	return bucket, prefix, nil
+}
+
+// storageEndpoint returns the url of the Cloud Storage JSON API, it can be
+// pointed to an emulator via STORAGE_EMULATOR_HOST like the google SDKs.
+func storageEndpoint() string {
+	host := os.Getenv("STORAGE_EMULATOR_HOST")
+	if host == "" {
+		return defaultStorageEndpoint
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return strings.TrimRight(host, "/")
+}
+
+// StorageClient is a minimal client for the Cloud Storage JSON API, it only
+// supports what's needed to stage the source archives.
+type StorageClient struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func NewStorageClient(endpoint string, token string) *StorageClient {
+	return &StorageClient{
+		endpoint: endpoint,
+		token:    token,
+		client:   &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+func (c *StorageClient) do(method string, u string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/zip")
+	}
+	return c.client.Do(req)
+}
+
+// Exists returns true if the object exists in the bucket.
+func (c *StorageClient) Exists(bucket string, object string) (bool, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s", c.endpoint, url.PathEscape(bucket), url.PathEscape(object))
+	resp, err := c.do(http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, storageError(resp, bucket, object)
+}
+
+// Upload creates the object with the content of r, existing objects are
+// left as they are.
+func (c *StorageClient) Upload(bucket string, object string, r io.Reader) error {
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&ifGenerationMatch=0&name=%s", c.endpoint, url.PathEscape(bucket), url.QueryEscape(object))
+	resp, err := c.do(http.MethodPost, u, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPreconditionFailed:
+		// precondition failed means the object was created in the meantime
+		return nil
+	}
+	return storageError(resp, bucket, object)
+}
+
+func storageError(resp *http.Response, bucket string, object string) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	msg := strings.TrimSpace(string(b))
+
+	res := struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	if json.Unmarshal(b, &res) == nil && res.Error.Message != "" {
+		msg = res.Error.Message
+	}
+	return fmt.Errorf("gs://%s/%s: %s: %s", bucket, object, resp.Status, msg)
+}
+
+// stageSources uploads the archive of every local source, either a directory
+// or a prebuilt zip file, to the staging bucket unless the same archive is
+// already there, and changes the sources of the functions to the uploaded
+// objects. Each function's source is uploaded with the client from clients.
+func stageSources(cfg *Config, clients func(f Function) (*StorageClient, error)) error {
+	for i, f := range cfg.Functions {
+		if isRemoteSource(f.Source) {
+			continue
+		}
+		client, err := clients(f)
+		if err != nil {
+			return err
+		}
+
+		// functions often share their source, it's only zipped once
+		a, err := cfg.sourceArchive(sourceDir(cfg.Dir, f))
+		if err != nil {
+			return fmt.Errorf("can't stage source of function %s: %s", f.Name, err)
+		}
+		gsURL, err := stageSource(cfg, client, a)
+		if err != nil {
+			return fmt.Errorf("can't stage source of function %s: %s", f.Name, err)
+		}
+		cfg.Functions[i].Source = gsURL
+		cfg.Functions[i].sourceDigest = a.SHA256
+	}
+	return nil
+}
+
+func stageSource(cfg *Config, client *StorageClient, a *SourceArchive) (string, error) {
+	object := cfg.StagingPrefix + a.SHA256 + ".zip"
+	gsURL := "gs://" + cfg.StagingBucket + "/" + object
+	if cfg.staged[gsURL] {
+		return gsURL, nil
+	}
+
+	if cfg.DryRun {
+		log.Printf("Dry run, not uploading source %s to %s", a.Dir, gsURL)
+	} else if err := uploadArchive(client, cfg.StagingBucket, object, a); err != nil {
+		return "", err
+	}
+
+	if cfg.staged == nil {
+		cfg.staged = map[string]bool{}
+	}
+	cfg.staged[gsURL] = true
+	return gsURL, nil
+}
+
+func uploadArchive(client *StorageClient, bucket string, object string, a *SourceArchive) error {
+	exists, err := client.Exists(bucket, object)
+	if err != nil {
+		return err
+	}
+	if exists {
+		log.Printf("Source %s is already staged at gs://%s/%s", a.Dir, bucket, object)
+		return nil
+	}
+
+	fh, err := os.Open(a.Path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	if err := client.Upload(bucket, object, fh); err != nil {
+		return err
+	}
+	log.Printf("Uploaded source %s to gs://%s/%s", a.Dir, bucket, object)
+	return nil
+}
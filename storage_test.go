@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeStorage implements the parts of the Cloud Storage JSON API that are
+// used by StorageClient, objects are kept in memory.
+type fakeStorage struct {
+	sync.Mutex
+	objects map[string][]byte
+	uploads int
+}
+
+func newFakeStorage(t *testing.T) (*fakeStorage, *httptest.Server) {
+	fs := &fakeStorage{objects: map[string][]byte{}}
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+	return fs, srv
+}
+
+// storageClients returns c for every function.
+func storageClients(c *StorageClient) func(f Function) (*StorageClient, error) {
+	return func(f Function) (*StorageClient, error) { return c, nil }
+}
+
+func (fs *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		http.Error(w, `{"error": {"message": "Invalid Credentials"}}`, http.StatusUnauthorized)
+		return
+	}
+
+	p := r.URL.EscapedPath()
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "/storage/v1/b/"):
+		parts := strings.SplitN(strings.TrimPrefix(p, "/storage/v1/b/"), "/o/", 2)
+		object, _ := url.PathUnescape(parts[1])
+		if _, ok := fs.objects[parts[0]+"/"+object]; !ok {
+			http.Error(w, `{"error": {"message": "No such object"}}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{}`))
+
+	case r.Method == http.MethodPost && strings.HasPrefix(p, "/upload/storage/v1/b/"):
+		bucket := strings.TrimSuffix(strings.TrimPrefix(p, "/upload/storage/v1/b/"), "/o")
+		key := bucket + "/" + r.URL.Query().Get("name")
+		if _, ok := fs.objects[key]; ok && r.URL.Query().Get("ifGenerationMatch") == "0" {
+			http.Error(w, `{"error": {"message": "Precondition Failed"}}`, http.StatusPreconditionFailed)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		fs.objects[key] = b
+		fs.uploads++
+		w.Write([]byte(`{}`))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestParseStagingBucket(t *testing.T) {
+	for _, tst := range []struct {
+		in     string
+		bucket string
+		prefix string
+		err    bool
+	}{
+		{in: "my-bucket", bucket: "my-bucket", prefix: "drone-gcf/"},
+		{in: "gs://my-bucket", bucket: "my-bucket", prefix: "drone-gcf/"},
+		{in: "gs://my-bucket/", bucket: "my-bucket", prefix: ""},
+		{in: "gs://my-bucket/builds/functions/", bucket: "my-bucket", prefix: "builds/functions/"},
+		{in: "gs://My_Bucket", err: true},
+		{in: "gs://", err: true},
+	} {
+		bucket, prefix, err := parseStagingBucket(tst.in)
+		if tst.err {
+			if err == nil {
+				t.Errorf("parseStagingBucket(%s) expected error", tst.in)
+			}
+			continue
+		}
+		if err != nil || bucket != tst.bucket || prefix != tst.prefix {
+			t.Errorf("parseStagingBucket(%s) got: %s %s   err: %v", tst.in, bucket, prefix, err)
+		}
+	}
+}
+
+func TestStorageClient(t *testing.T) {
+	_, srv := newFakeStorage(t)
+	c := NewStorageClient(srv.URL, "test-token")
+
+	if ok, err := c.Exists("bucket", "a/b.zip"); err != nil || ok {
+		t.Errorf("Exists() got: %t   err: %v", ok, err)
+	}
+	if err := c.Upload("bucket", "a/b.zip", strings.NewReader("zip")); err != nil {
+		t.Errorf("Upload() err: %s", err)
+	}
+	if ok, err := c.Exists("bucket", "a/b.zip"); err != nil || !ok {
+		t.Errorf("Exists() got: %t   err: %v", ok, err)
+	}
+	if err := c.Upload("bucket", "a/b.zip", strings.NewReader("zip")); err != nil {
+		t.Errorf("Upload() of existing object err: %s", err)
+	}
+
+	c = NewStorageClient(srv.URL, "wrong-token")
+	_, err := c.Exists("bucket", "a/b.zip")
+	if err == nil || !strings.Contains(err.Error(), "gs://bucket/a/b.zip: 401 Unauthorized: Invalid Credentials") {
+		t.Errorf("expected credentials error, got: %v", err)
+	}
+}
+
+func TestStorageEndpoint(t *testing.T) {
+	defer os.Unsetenv("STORAGE_EMULATOR_HOST")
+
+	os.Unsetenv("STORAGE_EMULATOR_HOST")
+	if e := storageEndpoint(); e != defaultStorageEndpoint {
+		t.Errorf("storageEndpoint() got: %s", e)
+	}
+	os.Setenv("STORAGE_EMULATOR_HOST", "localhost:9023")
+	if e := storageEndpoint(); e != "http://localhost:9023" {
+		t.Errorf("storageEndpoint() got: %s", e)
+	}
+}
+
+func TestStageSources(t *testing.T) {
+	fs, srv := newFakeStorage(t)
+	client := NewStorageClient(srv.URL, "test-token")
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"shared/function.go": "package function\n",
+		"other/function.go":  "package other\n",
+	})
+
+	functions := func() Functions {
+		return Functions{
+			{Name: "A", Source: "shared"},
+			{Name: "B", Source: "./shared/"},
+			{Name: "C", Source: "other"},
+			{Name: "D", Source: "gs://elsewhere/source.zip"},
+		}
+	}
+
+	cfg := &Config{Dir: dir, StagingBucket: "bucket", StagingPrefix: "drone-gcf/", Functions: functions()}
+	defer cfg.removeArchives()
+	if err := stageSources(cfg, storageClients(client)); err != nil {
+		t.Fatalf("stageSources() err: %s", err)
+	}
+	if fs.uploads != 2 {
+		t.Errorf("expected 2 uploads, got: %d", fs.uploads)
+	}
+
+	a := cfg.Functions[0].Source
+	if !strings.HasPrefix(a, "gs://bucket/drone-gcf/") || !strings.HasSuffix(a, ".zip") {
+		t.Errorf("unexpected source: %s", a)
+	}
+	if cfg.Functions[1].Source != a {
+		t.Errorf("expected shared source %s, got: %s", a, cfg.Functions[1].Source)
+	}
+	if cfg.Functions[2].Source == a {
+		t.Errorf("expected different source for C, got: %s", cfg.Functions[2].Source)
+	}
+	if cfg.Functions[3].Source != "gs://elsewhere/source.zip" {
+		t.Errorf("remote source was changed: %s", cfg.Functions[3].Source)
+	}
+
+	// identical sources of later builds are reused
+	cfg2 := &Config{Dir: dir, StagingBucket: "bucket", StagingPrefix: "drone-gcf/", Functions: functions()}
+	defer cfg2.removeArchives()
+	if err := stageSources(cfg2, storageClients(client)); err != nil {
+		t.Fatalf("stageSources() err: %s", err)
+	}
+	if fs.uploads != 2 {
+		t.Errorf("expected no more uploads, got: %d", fs.uploads)
+	}
+	if cfg2.Functions[0].Source != a {
+		t.Errorf("expected same object %s, got: %s", a, cfg2.Functions[0].Source)
+	}
+
+	plan, err := CreateExecutionPlan(&Config{Action: "deploy", Functions: Functions{{Name: "A", Runtime: "go121", Trigger: "http", Source: a}}})
+	if err != nil || !strings.Contains(strings.Join(plan.Steps[0], " "), "--source "+a) {
+		t.Errorf("expected --source %s in plan, got: %v   err: %v", a, plan.Steps, err)
+	}
+
+	// dry runs don't need the bucket
+	cfg3 := &Config{Dir: dir, DryRun: true, StagingBucket: "bucket", StagingPrefix: "x/", Functions: functions()}
+	defer cfg3.removeArchives()
+	if err := stageSources(cfg3, storageClients(NewStorageClient("http://127.0.0.1:1", ""))); err != nil {
+		t.Errorf("stageSources() in dry run err: %s", err)
+	}
+}
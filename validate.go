@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ConfigErrors collects every problem found in the config so they can all be
+// reported at once instead of failing on the first one.
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	lines := []string{fmt.Sprintf("found %d problems in config:", len(e))}
+	for _, err := range e {
+		lines = append(lines, "  - "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *ConfigErrors) Add(format string, args ...interface{}) {
+	*e = append(*e, fmt.Errorf(format, args...))
+}
+
+// Append adds err to the list, flattening it if it's a ConfigErrors itself.
+func (e *ConfigErrors) Append(prefix string, err error) {
+	if err == nil {
+		return
+	}
+	if errs, ok := err.(ConfigErrors); ok {
+		for _, err := range errs {
+			e.Append(prefix, err)
+		}
+		return
+	}
+	if prefix != "" {
+		err = fmt.Errorf("%s: %s", prefix, err)
+	}
+	*e = append(*e, err)
+}
+
+// Err returns nil if no errors were collected so it can be returned directly.
+func (e ConfigErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// decodeFunction expands the variables in the settings of a single function,
+// validates them against the function schema and decodes them strictly.
+func decodeFunction(raw json.RawMessage, vars map[string]string) (Function, error) {
+	f := Function{}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return f, err
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		return f, fmt.Errorf("settings must be an object, got: %s", raw)
+	}
+
+	v, err := expandTree(v, "", vars)
+	if err != nil {
+		return f, err
+	}
+
+	if errs := functionSchema().Validate(v); len(errs) > 0 {
+		return f, errs
+	}
+
+	expanded, err := json.Marshal(v)
+	if err != nil {
+		return f, err
+	}
+	dec = json.NewDecoder(bytes.NewReader(expanded))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return f, fmt.Errorf("invalid settings: %s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return f, nil
+}
+
+// suggest returns the candidate closest to s or an empty string if nothing
+// is close enough to be a plausible typo.
+func suggest(s string, candidates []string) string {
+	s = strings.ToLower(s)
+	best, bestDist := "", -1
+	for _, c := range candidates {
+		d := levenshtein(s, strings.ToLower(c))
+		if bestDist == -1 || d < bestDist || (d == bestDist && c < best) {
+			best, bestDist = c, d
+		}
+	}
+
+	maxDist := len(s) / 3
+	if maxDist < 2 {
+		maxDist = 2
+	}
+	if bestDist == -1 || bestDist > maxDist || bestDist >= len(s) {
+		return ""
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(v int, vs ...int) int {
+	for _, x := range vs {
+		if x < v {
+			v = x
+		}
+	}
+	return v
+}
+
+var emailRegex = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+func isValidEmail(s string) bool {
+	return emailRegex.MatchString(s)
+}
+
+func sortedKeys(m map[string]string) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func containsString(l []string, s string) bool {
+	for _, x := range l {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFunctionForDeploy returns all problems that would prevent f from
+// being deployed.
+func validateFunctionForDeploy(f Function) ConfigErrors {
+	errs := ConfigErrors{}
+
+	if err := validateRuntime(f.Runtime); err != nil {
+		errs.Add("%s", err)
+	}
+
+	if f.IngressSettings != "" && !isValidIngressSettings(f.IngressSettings) {
+		errs.Add("invalid ingress settings [%s]", f.IngressSettings)
+	}
+
+	if f.EgressSettings != "" && !isValidEgressSettings(f.EgressSettings) {
+		errs.Add("invalid egress settings [%s]", f.EgressSettings)
+	}
+
+	if err := validateSourceLocation(f.Source); err != nil {
+		errs.Add("%s", err)
+	}
+
+	if f.Trigger == "http" && f.HttpSecurityLevel != "" && !isValidSecureType(f.HttpSecurityLevel) {
+		errs.Add("invalid security level [%s] for http trigger", f.HttpSecurityLevel)
+	}
+
+	if (f.Trigger == "" && f.TriggerEvent == "" && f.TriggerResource == "") || !isValidTriggerType(f.Trigger) {
+		errs.Add("missing or invalid trigger [%s]", f.Trigger)
+		return errs
+	}
+
+	if f.Trigger == "eventarc" {
+		errs = append(errs, validateEventarcTrigger(f)...)
+	} else {
+		if f.Trigger != "http" && f.TriggerResource == "" {
+			errs.Add("missing trigger resource for %s trigger", f.Trigger)
+		}
+		if len(f.EventFilters) > 0 || len(f.EventFiltersPathPattern) > 0 || f.TriggerLocation != "" || f.TriggerServiceAccount != "" {
+			errs.Add("event_filters, event_filters_path_pattern, trigger_location and trigger_service_account are only supported by the eventarc trigger")
+		}
+	}
+
+	if f.Trigger == "event" && f.TriggerEvent == "" {
+		errs.Add("missing trigger event")
+	}
+
+	errs = append(errs, validateGeneration(f)...)
+	errs = append(errs, validateScaling(f)...)
+	errs = append(errs, validateBuild(f.Build)...)
+	errs = append(errs, validateSecrets(f)...)
+	return errs
+}
+
+// validateEventarcTrigger checks the filters of an eventarc trigger, the
+// event type is given by the "type" filter.
+func validateEventarcTrigger(f Function) ConfigErrors {
+	errs := ConfigErrors{}
+
+	if !f.Gen2 {
+		errs.Add("eventarc trigger requires gen2: true")
+	}
+	if f.TriggerEvent != "" || f.TriggerResource != "" {
+		errs.Add("trigger_event and trigger_resource can't be used with the eventarc trigger, use event_filters")
+	}
+	if f.EventFilters["type"] == "" {
+		errs.Add("missing event type, set the type in event_filters")
+	}
+
+	for _, k := range sortedKeys(f.EventFiltersPathPattern) {
+		if k == "type" {
+			errs.Add("the event type can't be a path pattern")
+		}
+		if _, ok := f.EventFilters[k]; ok {
+			errs.Add("event filter %s is set in event_filters and event_filters_path_pattern", k)
+		}
+	}
+	for _, filters := range []map[string]string{f.EventFilters, f.EventFiltersPathPattern} {
+		for _, k := range sortedKeys(filters) {
+			if k == "" || filters[k] == "" {
+				errs.Add("invalid event filter %q: %q, attribute and value are required", k, filters[k])
+			}
+		}
+	}
+
+	if f.TriggerServiceAccount != "" && !isValidEmail(f.TriggerServiceAccount) {
+		errs.Add("invalid trigger_service_account %q, expected an email address", f.TriggerServiceAccount)
+	}
+	return errs
+}
+
+// validateRuntime checks that r is in the catalog or newer than the runtimes
+// of its family in it. The lifecycle is checked separately as it might be
+// refreshed from gcloud first.
+func validateRuntime(r string) error {
+	switch {
+	case isValidRuntime(r) || isNewerRuntime(r):
+		return nil
+	case r == "":
+		return fmt.Errorf("missing runtime")
+	}
+	if sg := suggest(r, validRuntimes); sg != "" {
+		return fmt.Errorf("invalid value %q for setting \"runtime\", did you mean %q?", r, sg)
+	}
+	return fmt.Errorf("invalid value %q for setting \"runtime\", must be one of: %s", r, strings.Join(runtimeSettingValues(), ", "))
+}
+
+// functionWarnings returns the problems of f that are only logged, e.g.
+// deprecated runtimes.
+func functionWarnings(f Function) []string {
+	res := []string{}
+	if w := runtimeDeprecationWarning(f.Runtime); w != "" {
+		res = append(res, w)
+	}
+	if isNewerRuntime(f.Runtime) {
+		res = append(res, fmt.Sprintf("runtime %s isn't known to the plugin yet, its lifecycle isn't checked", f.Runtime))
+	}
+	res = append(res, generationWarnings(f)...)
+	return append(res, scalingWarnings(f)...)
+}
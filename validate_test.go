@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseFunctionsStrict(t *testing.T) {
+	for _, tst := range []struct {
+		in               string
+		expectedErrors   int
+		expectedContains []string
+	}{
+		{
+			in:               `[{"TransferFile":[{"t":"http"}]}]`,
+			expectedErrors:   1,
+			expectedContains: []string{`function TransferFile: unknown setting "t"`},
+		},
+		{
+			in:               `[{"TransferFile":[{"triger":"http","memmory":"512MB"}]}]`,
+			expectedErrors:   2,
+			expectedContains: []string{`unknown setting "triger", did you mean "trigger"?`, `unknown setting "memmory", did you mean "memory"?`},
+		},
+		{
+			in:               `[{"FuncA":[{"trigger":"http","regoin":"us-east1"}]},{"FuncB":[{"trigger":"http","gen2":"yes"}]}]`,
+			expectedErrors:   2,
+			expectedContains: []string{`function FuncA: unknown setting "regoin", did you mean "region"?`, `function FuncB: setting "gen2" must be a boolean, got string`},
+		},
+		{
+			in:               `[{"TransferFile":[{"trigger":"http",`,
+			expectedErrors:   1,
+			expectedContains: []string{"invalid functions setting"},
+		},
+		{
+			in:               `[{"TransferFile":["http"]}]`,
+			expectedErrors:   1,
+			expectedContains: []string{"settings must be an object"},
+		},
+	} {
+		_, err := parseFunctions(tst.in, "go111", nil)
+		if err == nil {
+			t.Errorf("parseFunctions(%s) should have failed", tst.in)
+			continue
+		}
+
+		n := 1
+		if errs, ok := err.(ConfigErrors); ok {
+			n = len(errs)
+		}
+		if n != tst.expectedErrors {
+			t.Errorf("parseFunctions(%s) expected %d errors, got %d: %s", tst.in, tst.expectedErrors, n, err)
+		}
+		for _, s := range tst.expectedContains {
+			if !strings.Contains(err.Error(), s) {
+				t.Errorf("parseFunctions(%s) expected error to contain %q, got: %s", tst.in, s, err)
+			}
+		}
+	}
+}
+
+func TestSuggestSetting(t *testing.T) {
+	known := []string{}
+	for k := range functionSchema().Properties {
+		known = append(known, k)
+	}
+	for in, expected := range map[string]string{
+		"triger":          "trigger",
+		"Trigger_Resorce": "trigger_resource",
+		"entry_point":     "entrypoint",
+		"service_account": "serviceaccount",
+		"t":               "",
+		"completely_off":  "",
+	} {
+		if s := suggest(in, known); s != expected {
+			t.Errorf("suggest(%s) got: %q   expected: %q", in, s, expected)
+		}
+	}
+}
+
+func TestValidateFunctionForDeployCollectsAll(t *testing.T) {
+	errs := validateFunctionForDeploy(Function{
+		Name:            "Func",
+		Runtime:         "lol123",
+		Trigger:         "bucket",
+		IngressSettings: "nope",
+		EgressSettings:  "nope",
+	})
+	if len(errs) != 4 {
+		t.Errorf("expected 4 errors, got: %s", errs)
+	}
+}
+
+func TestParseConfigReportsAllErrors(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("PLUGIN_ACTION", "deploy")
+	os.Setenv("PLUGIN_TOKEN", validGCPKey)
+	os.Setenv("PLUGIN_FUNCTIONS", `[{"FuncA":[{"trigger":"http","runtime":"lol123"}]},{"FuncB":[{"trigger":"topic","runtime":"go111"}]},{"FuncC":[{"trigger":"http","runtime":"go111"}]}]`)
+
+	_, err := parseConfig()
+	if err == nil {
+		t.Fatalf("parseConfig() should have failed")
+	}
+	for _, s := range []string{"found 2 problems", `function FuncA: invalid value "lol123" for setting "runtime"`, "function FuncB: missing trigger resource"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected error to contain %q, got: %s", s, err)
+		}
+	}
+}
+
+func TestValidateEventarcTrigger(t *testing.T) {
+	valid := Function{
+		Name:         "F",
+		Runtime:      "go121",
+		Trigger:      "eventarc",
+		Gen2:         true,
+		EventFilters: map[string]string{"type": "google.cloud.pubsub.topic.v1.messagePublished"},
+	}
+	if errs := validateFunctionForDeploy(valid); len(errs) > 0 {
+		t.Errorf("validateFunctionForDeploy() err: %s", errs)
+	}
+
+	f := valid
+	f.Gen2 = false
+	f.TriggerResource = "topic"
+	f.EventFilters = map[string]string{"bucket": ""}
+	f.EventFiltersPathPattern = map[string]string{"bucket": "x/*", "type": "y"}
+	f.TriggerServiceAccount = "not-an-email"
+	errs := validateEventarcTrigger(f)
+	for _, e := range []string{
+		"eventarc trigger requires gen2: true",
+		"trigger_event and trigger_resource can't be used with the eventarc trigger",
+		"missing event type",
+		"the event type can't be a path pattern",
+		"event filter bucket is set in event_filters and event_filters_path_pattern",
+		`invalid event filter "bucket": ""`,
+		`invalid trigger_service_account "not-an-email"`,
+	} {
+		if !strings.Contains(errs.Error(), e) {
+			t.Errorf("expected %q in errors, got: %s", e, errs)
+		}
+	}
+
+	f = Function{Name: "F", Runtime: "go121", Trigger: "http", TriggerLocation: "us-central1"}
+	if errs := validateFunctionForDeploy(f); len(errs) != 1 || !strings.Contains(errs[0].Error(), "only supported by the eventarc trigger") {
+		t.Errorf("expected eventarc settings error, got: %v", errs)
+	}
+}